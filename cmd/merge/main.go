@@ -0,0 +1,197 @@
+/*
+Small helper binary to merge the databases and blobs of multiple sites/cameras into a single data directory.
+Sightings which were recorded without site (see --site) are assigned the site given here, and their blobs are copied
+under new names, to be uploaded again.
+This fails if their blobs were already cleaned up locally after upload, in which case they first have to be restored
+from the remote storage.
+Merging the same input again only copies new sightings.
+Usage:
+
+	go run ./cmd/merge/ --data-dir=data-merged site-a=data-a site-b=data-b
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"jo-m.ch/go/trainbot/internal/pkg/db"
+	"jo-m.ch/go/trainbot/internal/pkg/logging"
+	"jo-m.ch/go/trainbot/internal/pkg/upload"
+)
+
+type input struct {
+	site  string
+	store upload.DataStore
+}
+
+type config struct {
+	logging.LogConfig
+
+	upload.DataStore
+
+	Inputs []string `arg:"positional,required" help:"Data directories to merge, as SITE=DIR" placeholder:"SITE=DIR"`
+}
+
+func (c *config) getInputs() ([]input, error) {
+	ret := make([]input, 0, len(c.Inputs))
+	for _, in := range c.Inputs {
+		site, dir, ok := strings.Cut(in, "=")
+		if !ok || dir == "" {
+			return nil, errors.New("inputs must be given as SITE=DIR")
+		}
+		err := db.ValidateSite(site)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, input{site, upload.DataStore{DataDir: dir}})
+	}
+
+	return ret, nil
+}
+
+func (c *config) mustOpenDB() *sqlx.DB {
+	err := os.MkdirAll(c.GetBlobPath(""), 0750)
+	if err != nil {
+		log.Panic().Err(err).Msg("could not create data directory")
+	}
+
+	dbx, err := db.Open(c.GetDBPath())
+	if err != nil {
+		log.Panic().Err(err).Msg("could not create/open database")
+	}
+
+	return dbx
+}
+
+func parseCheckArgs() (config, []input) {
+	c := config{}
+	p := arg.MustParse(&c)
+	logging.MustInit(c.LogConfig)
+
+	inputs, err := c.getInputs()
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	return c, inputs
+}
+
+// mustOpenInputDB opens a copy of an input database, upgraded to the latest schema.
+// The input database itself is not modified.
+func mustOpenInputDB(in input, tmpDir string) *sqlx.DB {
+	src, err := db.OpenReadOnly(in.store.GetDBPath())
+	if err != nil {
+		log.Panic().Err(err).Str("site", in.site).Msg("could not open input database")
+	}
+	defer src.Close()
+
+	tmpPath := filepath.Join(tmpDir, in.site+".sqlite3")
+	err = db.Backup(src, tmpPath)
+	if err != nil {
+		log.Panic().Err(err).Str("site", in.site).Msg("could not copy input database")
+	}
+
+	dbx, err := db.Open(tmpPath)
+	if err != nil {
+		log.Panic().Err(err).Str("site", in.site).Msg("could not upgrade input database")
+	}
+
+	return dbx
+}
+
+func copyFile(srcPath, dstPath string) error {
+	// #nosec G304
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// #nosec G304
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// copyBlobs copies the blobs of a merged train sighting.
+// Blobs which were cleaned up after upload are skipped, as long as their names did not change.
+// Blobs which are missing otherwise are reported and skipped.
+// Returns the number of copied blobs.
+func copyBlobs(src, dst upload.DataStore, m db.MergedTrain) (int, error) {
+	paths := [][2]string{
+		{src.GetBlobPath(m.Src.ImgFileName()), dst.GetBlobPath(m.Dst.ImgFileName())},
+		{src.GetBlobThumbPath(m.Src.ImgFileName()), dst.GetBlobThumbPath(m.Dst.ImgFileName())},
+		{src.GetBlobPath(m.Src.GIFFileName()), dst.GetBlobPath(m.Dst.GIFFileName())},
+	}
+
+	n := 0
+	for _, p := range paths {
+		err := copyFile(p[0], p[1])
+		if errors.Is(err, fs.ErrNotExist) && m.CleanedUp && !m.SiteAssigned() {
+			log.Debug().Str("path", p[0]).Msg("blob was cleaned up, skipping")
+			continue
+		}
+		if errors.Is(err, fs.ErrNotExist) && m.CleanedUp {
+			return n, fmt.Errorf("blob %s was cleaned up and can not be renamed, restore it from the remote storage first", p[0])
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// Not cleaned up, so it was already missing in the source data directory.
+			log.Warn().Str("path", p[0]).Int64("id", m.Src.ID).Msg("blob does not exist, skipping")
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+func main() {
+	c, inputs := parseCheckArgs()
+
+	dst := c.mustOpenDB()
+	defer dst.Close()
+
+	tmpDir, err := os.MkdirTemp("", "trainbot-merge-")
+	if err != nil {
+		log.Panic().Err(err).Send()
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, in := range inputs {
+		src := mustOpenInputDB(in, tmpDir)
+		nBlobs := 0
+		merged, err := db.Merge(dst, src, in.site, func(m db.MergedTrain) error {
+			n, err := copyBlobs(in.store, c.DataStore, m)
+			nBlobs += n
+			return err
+		})
+		src.Close()
+		if err != nil {
+			log.Panic().Err(err).Str("site", in.site).Msg("could not merge database, blobs copied so far can be removed using cmd/cleanup")
+		}
+
+		log.Info().Str("site", in.site).Str("dir", in.store.DataDir).Int("trains", len(merged)).Int("blobs", nBlobs).Msg("merged")
+	}
+}
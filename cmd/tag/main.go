@@ -0,0 +1,208 @@
+/*
+Small helper binary to manually tag (label) train sightings, e.g. with operator, train type or 'false-positive'.
+Tags are stored in the database and are part of the uploaded database, so the frontend can filter on them.
+Usage:
+
+	# Apply tags to train sightings 12, 13 and 20 to 25.
+	go run ./cmd/tag/ add --tag=SBB --tag=ICN 12 13 20-25
+	# Apply tags from a CSV file with lines of TRAIN_ID,TAG (e.g. from a spreadsheet).
+	go run ./cmd/tag/ add --file=tags.csv
+	# Remove tags.
+	go run ./cmd/tag/ remove --tag=ICN 13
+	# List all tags, or the train sightings with a tag.
+	go run ./cmd/tag/ list
+	go run ./cmd/tag/ list --tag=ICN
+*/
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"jo-m.ch/go/trainbot/internal/pkg/db"
+	"jo-m.ch/go/trainbot/internal/pkg/logging"
+	"jo-m.ch/go/trainbot/internal/pkg/upload"
+)
+
+type tagsArgs struct {
+	Tags []string `arg:"-t,--tag,separate" help:"Tag, can be repeated" placeholder:"TAG"`
+	File string   `arg:"-f,--file" help:"CSV file with lines of TRAIN_ID,TAG, in addition to --tag and IDS. - for stdin" placeholder:"FILE"`
+	IDs  []string `arg:"positional" help:"Train sighting ids or id ranges (e.g. 20-25) to apply --tag to" placeholder:"IDS"`
+}
+
+type listArgs struct {
+	Tag string `arg:"-t,--tag" help:"List train sightings with this tag instead of all tags" placeholder:"TAG"`
+}
+
+type config struct {
+	logging.LogConfig
+
+	upload.DataStore
+
+	Add    *tagsArgs `arg:"subcommand:add" help:"Apply tags to train sightings"`
+	Remove *tagsArgs `arg:"subcommand:remove" help:"Remove tags from train sightings"`
+	List   *listArgs `arg:"subcommand:list" help:"List tags"`
+}
+
+func (c *config) mustOpenDB() *sqlx.DB {
+	dbx, err := db.Open(c.GetDBPath())
+	if err != nil {
+		log.Panic().Err(err).Msg("could not create/open database")
+	}
+
+	return dbx
+}
+
+func parseCheckArgs() config {
+	c := config{}
+	p := arg.MustParse(&c)
+	logging.MustInit(c.LogConfig)
+
+	if p.Subcommand() == nil {
+		p.Fail("missing subcommand")
+	}
+
+	return c
+}
+
+// parseIDs parses train sighting ids and inclusive id ranges, e.g. "12" or "20-25".
+func parseIDs(args []string) ([]int64, error) {
+	ret := []int64{}
+	for _, a := range args {
+		fromS, toS, isRange := strings.Cut(a, "-")
+		from, err := strconv.ParseInt(fromS, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id '%s'", a)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.ParseInt(toS, 10, 64)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("invalid id range '%s'", a)
+			}
+		}
+
+		for id := from; id <= to; id++ {
+			ret = append(ret, id)
+		}
+	}
+
+	return ret, nil
+}
+
+// parseCSV parses lines of TRAIN_ID,TAG.
+func parseCSV(r io.Reader) ([]db.TrainTag, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	ret := []db.TrainTag{}
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := strconv.ParseInt(rec[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id '%s'", rec[0])
+		}
+		ret = append(ret, db.TrainTag{TrainID: id, Tag: rec[1]})
+	}
+}
+
+func (a *tagsArgs) mustGetTags() []db.TrainTag {
+	ids, err := parseIDs(a.IDs)
+	if err != nil {
+		log.Panic().Err(err).Send()
+	}
+	if len(ids) > 0 && len(a.Tags) == 0 {
+		log.Panic().Msg("no --tag given")
+	}
+
+	ret := []db.TrainTag{}
+	for _, id := range ids {
+		for _, tag := range a.Tags {
+			ret = append(ret, db.TrainTag{TrainID: id, Tag: tag})
+		}
+	}
+
+	if a.File != "" {
+		f := os.Stdin
+		if a.File != "-" {
+			// #nosec G304
+			f, err = os.Open(a.File)
+			if err != nil {
+				log.Panic().Err(err).Send()
+			}
+			defer f.Close()
+		}
+
+		fromFile, err := parseCSV(f)
+		if err != nil {
+			log.Panic().Err(err).Str("file", a.File).Msg("could not parse CSV file")
+		}
+		ret = append(ret, fromFile...)
+	}
+
+	return ret
+}
+
+func list(dbx *sqlx.DB, a *listArgs) {
+	if a.Tag == "" {
+		counts, err := db.ListTags(dbx)
+		if err != nil {
+			log.Panic().Err(err).Send()
+		}
+		for _, c := range counts {
+			fmt.Printf("%s\t%d\n", c.Tag, c.NTrains)
+		}
+		return
+	}
+
+	trains, err := db.GetTrainsByTag(dbx, a.Tag)
+	if err != nil {
+		log.Panic().Err(err).Send()
+	}
+	for _, t := range trains {
+		fmt.Printf("%d\t%s\t%s\n", t.ID, t.StartTS.Format("2006-01-02 15:04:05.999Z07:00"), t.Site)
+	}
+}
+
+func main() {
+	c := parseCheckArgs()
+
+	dbx := c.mustOpenDB()
+	defer dbx.Close()
+
+	switch {
+	case c.Add != nil:
+		tags := c.Add.mustGetTags()
+		n, err := db.AddTags(dbx, tags)
+		if err != nil {
+			log.Panic().Err(err).Msg("could not apply tags, none were applied")
+		}
+		log.Info().Int64("n", n).Int("skipped", len(tags)-int(n)).Msg("applied tags")
+	case c.Remove != nil:
+		tags := c.Remove.mustGetTags()
+		n, err := db.RemoveTags(dbx, tags)
+		if err != nil {
+			log.Panic().Err(err).Msg("could not remove tags, none were removed")
+		}
+		log.Info().Int64("n", n).Int("skipped", len(tags)-int(n)).Msg("removed tags")
+	case c.List != nil:
+		list(dbx, c.List)
+	}
+}
@@ -26,17 +26,30 @@ import (
 	"jo-m.ch/go/trainbot/internal/pkg/stitch"
 	"jo-m.ch/go/trainbot/internal/pkg/upload"
 	"jo-m.ch/go/trainbot/pkg/imutil"
+	"jo-m.ch/go/trainbot/pkg/pmatch"
 	"jo-m.ch/go/trainbot/pkg/vid"
 )
 
 type config struct {
 	logging.LogConfig
 
-	InputFile          string `arg:"--input,env:INPUT" help:"Video4linux device file or regular video file, e.g. /dev/video0, video.mp4, or 'picam3'" placeholder:"FILE"`
+	InputFile          string `arg:"--input,env:INPUT" help:"Video4linux device file, regular video file, directory of JPEG/PNG frames, network stream URL, or 'picam3', e.g. /dev/video0, video.mp4, frames/, rtsp://10.0.0.5/stream1, http://10.0.0.5/video.mjpeg" placeholder:"FILE"`
 	CameraFormatFourCC string `arg:"--camera-format-fourcc,env:CAMERA_FORMAT_FOURCC" default:"MJPG" help:"Camera pixel format FourCC string, ignored if using video file" placeholder:"CODE"`
 	CameraW            int    `arg:"--camera-w,env:CAMERA_W" default:"1920" help:"Camera frame size width, ignored if using video file or picam3" placeholder:"X"`
 	CameraH            int    `arg:"--camera-h,env:CAMERA_H" default:"1080" help:"Camera frame size height, ignored if using video file or picam3" placeholder:"Y"`
 
+	InputTSPattern string  `arg:"--input-ts-pattern,env:INPUT_TS_PATTERN" help:"Regex to extract frame timestamps from file names (first submatch), only if input is a directory without a timestamps.csv sidecar file" placeholder:"REGEX"`
+	InputTSLayout  string  `arg:"--input-ts-layout,env:INPUT_TS_LAYOUT" help:"Go time layout to parse frame timestamps from file names with, only if input is a directory. If empty, timestamps are parsed as seconds since the epoch" placeholder:"LAYOUT"`
+	InputFPS       float64 `arg:"--input-fps,env:INPUT_FPS" help:"Frame rate, only if input is a directory without timestamps" placeholder:"K"`
+
+	InputStart    time.Duration `arg:"--input-start,env:INPUT_START" help:"Start reading at this offset, e.g. 1h2m30s, only if input is a video file" placeholder:"DUR"`
+	InputDuration time.Duration `arg:"--input-duration,env:INPUT_DURATION" help:"Stop reading after this duration, only if input is a video file. If 0, read until the end" placeholder:"DUR"`
+	InputPixFmt   string        `arg:"--input-pix-fmt,env:INPUT_PIX_FMT" default:"rgba" help:"Pixel format to decode to (rgba, gray or yuv420p), only if input is a video file" placeholder:"FMT"`
+
+	RecordDir    string        `arg:"--record-dir,env:RECORD_DIR" help:"Record cropped frames to this directory, for later exact replay by passing it as --input" placeholder:"DIR"`
+	RecordMaxAge time.Duration `arg:"--record-max-age,env:RECORD_MAX_AGE" help:"Only keep this much of the recording, e.g. 30m. If 0, keep everything" placeholder:"DUR"`
+	RecordLossy  bool          `arg:"--record-lossy,env:RECORD_LOSSY" help:"Record frames as JPEG instead of PNG, to save disk space. Replay is then no longer pixel-exact"`
+
 	RectX uint `arg:"-X,--rect-x,env:RECT_X" help:"Rect to look at, x (left)" placeholder:"N"`
 	RectY uint `arg:"-Y,--rect-y,env:RECT_Y" help:"Rect to look at, y (top)" placeholder:"N"`
 	RectW uint `arg:"-W,--rect-w,env:RECT_W" help:"Rect to look at, width" placeholder:"N"`
@@ -44,11 +57,22 @@ type config struct {
 
 	Rotate180 bool `arg:"--rotate-180,env:ROTATE_180" help:"Rotate camera picture 180 degrees (only picam3)"`
 
+	Site string `arg:"--site,env:SITE" help:"Site/camera identifier (lower case letters, digits and dashes), needed to combine data from multiple sites. Empty for a single site" placeholder:"ID"`
+
 	PixelsPerM          float64 `arg:"--px-per-m,env:PX_PER_M" default:"45" help:"Pixels per meter, can be reconstructed from sleepers: they are usually 0.6m apart (in Europe)" placeholder:"K"`
 	MinSpeedKPH         float64 `arg:"--min-speed-kph,env:MIN_SPEED_KPH" default:"25" help:"Assumed train min speed, km/h" placeholder:"K"`
 	MaxSpeedKPH         float64 `arg:"--max-speed-kph,env:MAX_SPEED_KPH" default:"160" help:"Assumed train max speed, km/h" placeholder:"K"`
 	MinLengthM          float64 `arg:"--min-len-m,env:MIN_LEN_M" default:"5" help:"Minimum length of trains" placeholder:"K"`
-	MaxFrameCountPerSeq int     `arg:"--max-frame-count-per-seq,env:MAX_FRAME_COUNT_PER_SEQ" default:"1500" help:"How many frames to accept max. before force-ending a train sequence. If you have high fps videos/long trains, you can increase it from the default, but the program will use more memory." placeholder:"N"`
+	PreRollFrames       int     `arg:"--pre-roll-frames,env:PRE_ROLL_FRAMES" default:"10" help:"How many frames before the start of a detected train to re-evaluate and possibly include, so the front of the train is not cut off. 0 disables it" placeholder:"N"`
+	MaxDwellS           float64 `arg:"--max-dwell-s,env:MAX_DWELL_S" default:"0" help:"How long a train might stand still before it is considered gone [s]. 0 ends a train as soon as it stops" placeholder:"S"`
+	MaxDyPx             int     `arg:"--max-dy-px,env:MAX_DY_PX" default:"0" help:"Max vertical camera shake between two frames to compensate for [px]. Makes frame matching proportionally slower, 0 disables it" placeholder:"PX"`
+	Matcher             string  `arg:"--matcher,env:MATCHER" default:"default" help:"Frame matching implementation: default (exhaustive cosine similarity, multi-threaded), pyramid (coarse-to-fine, faster for high max speeds), phasecorr (FFT phase correlation, insensitive to exposure changes) or parallel (multi-threaded Go, used by default where C is built without OpenMP)" placeholder:"NAME"`
+	MatchScore          string  `arg:"--match-score,env:MATCH_SCORE" default:"cos" help:"Frame matching score: cos (cosine similarity), zncc (zero-mean normalized cross-correlation, insensitive to brightness changes), luma-cos or luma-zncc (same, but on luminance only)" placeholder:"NAME"`
+	NightMode           bool    `arg:"--night-mode,env:NIGHT_MODE" help:"Discard trains recorded in darkness which look like they were caused by lights (e.g. headlights) sweeping the scene"`
+	MinQuality          float64 `arg:"--min-quality,env:MIN_QUALITY" default:"0" help:"Trains with a quality score (0-1) below this are kept, but flagged and not uploaded. 0 disables it" placeholder:"K"`
+	Blend               string  `arg:"--blend,env:BLEND" default:"none" help:"How frames are combined in the stitched image: none (each frame overwrites the previous one) or feather (central strips with soft seams, hides exposure differences but needs more memory)" placeholder:"MODE"`
+	MaxFrameCountPerSeq int     `arg:"--max-frame-count-per-seq,env:MAX_FRAME_COUNT_PER_SEQ" default:"1500" help:"How many frames to accept max. before force-ending a train sequence. If you have high fps videos/long trains, you can increase it from the default, but the program will use more memory. Ignored with --stream-dir" placeholder:"N"`
+	StreamDir           string  `arg:"--stream-dir,env:STREAM_DIR" help:"Streaming mode: only keep a strip of each frame, spooled to a temporary file in this directory, so that trains of any length can be captured in bounded memory. --blend is ignored, and very long trains are scaled down. Should not be on a RAM disk" placeholder:"DIR"`
 
 	CPUProfile  bool `arg:"--cpu-profile,env:CPU_PROFILE" help:"Write CPU profile"`
 	HeapProfile bool `arg:"--heap-profile,env:HEAP_PROFILE" help:"Write memory heap profiles"`
@@ -66,6 +90,24 @@ func (c *config) getRect() image.Rectangle {
 	return image.Rect(0, 0, int(c.RectW), int(c.RectH)).Add(image.Pt(int(c.RectX), int(c.RectY)))
 }
 
+func (c *config) mustMatchMode() pmatch.Mode {
+	mode, err := pmatch.ParseMode(c.MatchScore)
+	if err != nil {
+		log.Panic().Err(err).Msg("invalid match score")
+	}
+
+	return mode
+}
+
+func (c *config) mustBlendMode() stitch.BlendMode {
+	mode, err := stitch.ParseBlendMode(c.Blend)
+	if err != nil {
+		log.Panic().Err(err).Msg("invalid blend mode")
+	}
+
+	return mode
+}
+
 func (c *config) mustOpenDB() *sqlx.DB {
 	dbx, err := db.Open(c.GetDBPath())
 	if err != nil {
@@ -80,6 +122,8 @@ const (
 	rectSizeMax = 500
 
 	failedFramesMax = 50
+	// Consecutive failed frames after which a live source is re-opened, must be < failedFramesMax.
+	reconnectFailedFramesMax = 10
 
 	inputFilePiCam3 = "picam3"
 
@@ -110,6 +154,26 @@ func parseCheckArgs() config {
 		p.Fail(fmt.Sprintf("rect is too large (maximum width and height is %d px)", rectSizeMax))
 	}
 
+	mode, err := pmatch.ParseMode(c.MatchScore)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	m, err := pmatch.NewMatcher(c.Matcher, mode)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	m.Destroy()
+
+	_, err = stitch.ParseBlendMode(c.Blend)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	err = db.ValidateSite(c.Site)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
 	return c
 }
 
@@ -125,14 +189,43 @@ func openSrc(c config) (vid.Src, error) {
 		})
 	}
 
+	// IP camera.
+	if vid.IsNetURL(c.InputFile) {
+		return vid.NewNetSrc(vid.NetConfig{
+			URL: c.InputFile,
+		})
+	}
+
 	stat, err := os.Stat(c.InputFile)
 	if err != nil {
 		return nil, err
 	}
 
+	if stat.IsDir() && vid.IsRecordingDir(c.InputFile) {
+		// Recording.
+		return vid.NewReplaySrc(vid.ReplayConfig{Dir: c.InputFile})
+	}
+
+	if stat.IsDir() {
+		// Image sequence.
+		return vid.NewDirSrc(vid.DirConfig{
+			Dir:       c.InputFile,
+			TSPattern: c.InputTSPattern,
+			TSLayout:  c.InputTSLayout,
+			FPS:       c.InputFPS,
+		})
+	}
+
 	if stat.Mode().IsRegular() {
 		// Video file.
-		return vid.NewFileSrc(c.InputFile, false)
+		return vid.NewFileSrc(vid.FileConfig{
+			Path:     c.InputFile,
+			Start:    c.InputStart,
+			Duration: c.InputDuration,
+			// Crop inside ffmpeg, cropping again later is a no-op.
+			Rect:   c.getRect(),
+			PixFmt: c.InputPixFmt,
+		})
 	}
 
 	return vid.NewCamSrc(vid.CamConfig{
@@ -145,10 +238,30 @@ func openSrc(c config) (vid.Src, error) {
 func detectTrainsForever(c config, trainsOut chan<- *stitch.Train) {
 	rect := c.getRect()
 
-	src, err := openSrc(c)
+	var src vid.Src
+	src, err := vid.NewReconnectingSrc(
+		func() (vid.Src, error) { return openSrc(c) },
+		vid.ReconnectConfig{MaxFailedFrames: reconnectFailedFramesMax},
+	)
 	if err != nil {
 		log.Panic().Err(err).Str("path", c.InputFile).Msg("failed to open video source")
 	}
+	if c.RecordDir != "" {
+		recRect := rect
+		if c.InputFile == inputFilePiCam3 {
+			// PiCam output is already cropped.
+			recRect = image.Rectangle{}
+		}
+		src, err = vid.NewRecordingSrc(src, vid.RecordingConfig{
+			Dir:    c.RecordDir,
+			Rect:   recRect,
+			Lossy:  c.RecordLossy,
+			MaxAge: c.RecordMaxAge,
+		})
+		if err != nil {
+			log.Panic().Err(err).Str("dir", c.RecordDir).Msg("failed to start recording")
+		}
+	}
 	defer src.Close()
 	srcBuf := vid.NewSrcBuf(src, failedFramesMax)
 
@@ -158,16 +271,34 @@ func detectTrainsForever(c config, trainsOut chan<- *stitch.Train) {
 		MaxSpeedKPH:         c.MaxSpeedKPH,
 		MinLengthM:          c.MinLengthM,
 		MaxFrameCountPerSeq: c.MaxFrameCountPerSeq,
+		PreRollFrames:       c.PreRollFrames,
+		MaxDwellS:           c.MaxDwellS,
+		MaxDyPx:             c.MaxDyPx,
+		Matcher:             c.Matcher,
+		MatchMode:           c.mustMatchMode(),
+		NightMode:           c.NightMode,
+		MinQuality:          c.MinQuality,
+		Blend:               c.mustBlendMode(),
+		Site:                c.Site,
+		Rect:                c.getRect(),
+		StreamDir:           c.StreamDir,
 	})
+	defer stitcher.Destroy()
 	defer func() {
-		train := stitcher.TryStitchAndReset()
-		if train != nil {
+		for _, train := range stitcher.TryStitchAndReset() {
 			trainsOut <- train
 		}
 	}()
 
 	for i := uint64(0); ; i++ {
 		frame, ts, err := srcBuf.GetFrame()
+		if errors.Is(err, vid.ErrReconnected) {
+			log.Warn().Msg("video source was re-opened, ending current sequence")
+			for _, train := range stitcher.TryStitchAndReset() {
+				trainsOut <- train
+			}
+			continue
+		}
 		if err != nil {
 			log.Err(err).Msg("no more frames")
 			break
@@ -193,8 +324,7 @@ func detectTrainsForever(c config, trainsOut chan<- *stitch.Train) {
 			log.Panic().Interface("cam", cropped.Bounds().Size()).Interface("conf", rect.Size()).Msg("rect size mismatch")
 		}
 
-		train := stitcher.Frame(cropped, *ts)
-		if train != nil {
+		for _, train := range stitcher.Frame(cropped, *ts) {
 			trainsOut <- train
 		}
 
@@ -226,15 +356,23 @@ func processTrains(store upload.DataStore, dbx *sqlx.DB, trainsIn <-chan *stitch
 
 	for train := range trainsIn {
 		log.Info().
+			Str("site", train.Site).
 			Time("ts", train.StartTS).
 			Float64("speedMpS", train.SpeedMpS()).
 			Float64("speedKmh", train.SpeedMpS()*3.6).
 			Float64("accelMpS2", train.AccelMpS2()).
+			Float64("durationS", train.DurationS()).
+			Float64("dwellS", train.DwellS).
+			Float64("shakePx", train.ShakePx).
+			Float64("quality", train.Quality.Score()).
+			Bool("flagged", train.Flagged).
+			Float64("imageScale", train.ImageScale).
+			Int("nCars", len(train.Cars)).
 			Str("direction", train.DirectionS()).
 			Msg("found train")
 
 		// Dump stitched image.
-		dbTrain := db.Train{StartTS: train.StartTS}
+		dbTrain := db.Train{Site: train.Site, StartTS: train.StartTS}
 		err := imutil.Dump(store.GetBlobPath(dbTrain.ImgFileName()), train.Image)
 		if err != nil {
 			log.Err(err).Send()
@@ -27,6 +27,7 @@ export async function loadDB(): Promise<SqlJs.Database> {
 
 export interface Train {
   id: number
+  site: string
   start_ts: DateTime
   n_frames: number
   length_px: number
@@ -123,6 +124,51 @@ export function getTrain(db: SqlJs.Database, id: number): Train | undefined {
   return convertRow(result[0].columns, result[0].values[0]) as Train
 }
 
+export interface TagCount {
+  tag: string
+  n_trains: number
+}
+
+// Escapes a string for use in an SQL string literal.
+export function sqlString(value: string): string {
+  return `'${value.replace(/'/g, "''")}'`
+}
+
+// SQL clause for Filter.where, matching train sightings with a tag.
+export function tagWhere(tag: string): string {
+  return `id IN (SELECT train_id FROM trains_v2_tags WHERE tag = ${sqlString(tag)})`
+}
+
+export function getTags(db: SqlJs.Database): TagCount[] {
+  const query = `
+  SELECT tag, COUNT(*) AS n_trains
+  FROM trains_v2_tags
+  GROUP BY tag
+  ORDER BY tag ASC`
+
+  console.log(query.trim())
+
+  const result = db.exec(query)
+  if (result.length === 0) return []
+
+  return result[0].values.map((row) => convertRow(result[0].columns, row)) as TagCount[]
+}
+
+export function getTrainTags(db: SqlJs.Database, id: number): string[] {
+  const query = `
+  SELECT tag
+  FROM trains_v2_tags
+  WHERE train_id = ${id}
+  ORDER BY tag ASC`
+
+  console.log(query.trim())
+
+  const result = db.exec(query)
+  if (result.length === 0) return []
+
+  return result[0].values.map((row) => row[0] as string)
+}
+
 export function queryOne(db: SqlJs.Database, query: string): SqlJs.SqlValue | undefined {
   console.log(query.trim())
   const result = db.exec(query)
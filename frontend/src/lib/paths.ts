@@ -11,12 +11,20 @@ function formatFileTs(ts: DateTime): string {
   return `${base}${frac}_${zone}`
 }
 
-export function imgFileName(ts: DateTime): string {
-  return `train_${formatFileTs(ts)}.jpg`
+// This matches db.Train.ImgFileName()/GIFFileName() from Go.
+function blobBaseName(ts: DateTime, site: string): string {
+  if (site === '') {
+    return `train_${formatFileTs(ts)}`
+  }
+  return `train_${site}_${formatFileTs(ts)}`
 }
 
-export function gifFileName(ts: DateTime): string {
-  return `train_${formatFileTs(ts)}.gif`
+export function imgFileName(ts: DateTime, site = ''): string {
+  return `${blobBaseName(ts, site)}.jpg`
+}
+
+export function gifFileName(ts: DateTime, site = ''): string {
+  return `${blobBaseName(ts, site)}.gif`
 }
 
 export function getBlobURL(blobName: string): string {
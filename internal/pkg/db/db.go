@@ -3,7 +3,6 @@ package db
 import (
 	"context"
 	"database/sql"
-	_ "embed"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -15,9 +14,6 @@ import (
 	sqlite3 "modernc.org/sqlite"
 )
 
-//go:embed schema.sql
-var sqlSchema string
-
 const driver = "sqlite"
 
 func buildDSN(path string, readOnly bool) string {
@@ -43,22 +39,33 @@ func buildDSN(path string, readOnly bool) string {
 }
 
 // Open creates a new SQLite database or opens an existing one.
-// Will run the schema/migration script.
+// Will run any missing schema migrations.
 func Open(path string) (*sqlx.DB, error) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("invalid migrations: %w", err)
+	}
+
 	db, err := sqlx.Open(driver, buildDSN(path, false))
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(sqlSchema)
+	err = migrate(db, migrations)
 	if err != nil {
 		_ = db.Close()
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
 	}
 
 	return db, err
 }
 
+// OpenReadOnly opens an existing SQLite database read-only.
+// Does not run any schema migrations, so the schema might not be the latest one.
+func OpenReadOnly(path string) (*sqlx.DB, error) {
+	return sqlx.Open(driver, buildDSN(path, true))
+}
+
 // Backup safely backs up a SQLite database to a new file.
 func Backup(src *sqlx.DB, destPath string) error {
 	err := os.Remove(destPath)
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Tables which reference trains_v2 via train_id, copied along with train sightings by Merge().
+var mergeChildTables = []string{
+	"trains_v2_quality",
+	"trains_v2_car_counts",
+	"trains_v2_cars",
+	"trains_v2_tags",
+}
+
+// MergedTrain is a train sighting which was copied by Merge().
+type MergedTrain struct {
+	// In the source database.
+	Src Train
+	// In the destination database.
+	Dst Train
+	// Blobs were already deleted locally in the source data directory, after upload.
+	CleanedUp bool
+}
+
+// SiteAssigned returns true if the sighting had no site and was assigned one, which changes its blob names.
+func (m *MergedTrain) SiteAssigned() bool {
+	return m.Src.Site != m.Dst.Site
+}
+
+// insertQuery builds a query which inserts row into table.
+func insertQuery(table string, row map[string]any) (string, []any) {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	slices.Sort(cols)
+
+	args := make([]any, len(cols))
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		args[i] = row[col]
+		quoted[i] = `"` + col + `"`
+	}
+
+	q := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table,
+		strings.Join(quoted, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+	return q, args
+}
+
+// Merge copies all train sightings, including quality, cars and tags, from src into dst, in a single transaction.
+// Both databases must have the latest schema, see Open().
+// Sightings without site are assigned site. As this changes their blob names, they are marked as not uploaded
+// and not cleaned up in dst. Sightings which already exist in dst (same site and start timestamp)
+// are skipped, so merging the same database again does not change anything.
+// copyBlobs is called for every copied sighting before committing, if it fails nothing is merged.
+// Returns the copied sightings.
+func Merge(dst, src *sqlx.DB, site string, copyBlobs func(MergedTrain) error) ([]MergedTrain, error) {
+	err := ValidateSite(site)
+	if err != nil {
+		return nil, err
+	}
+
+	var trains []struct {
+		Train
+		CleanedUp bool `db:"cleaned_up"`
+	}
+	err = src.Select(&trains, "SELECT id, site, start_ts, cleaned_up FROM trains_v2 ORDER BY id ASC;")
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := dst.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// No-op after a successful commit.
+		_ = tx.Rollback()
+	}()
+
+	var ret []MergedTrain
+	for _, train := range trains {
+		row := map[string]any{}
+		err := src.QueryRowx("SELECT * FROM trains_v2 WHERE id = ?;", train.ID).MapScan(row)
+		if err != nil {
+			return nil, err
+		}
+
+		merged := MergedTrain{Src: train.Train, Dst: train.Train, CleanedUp: train.CleanedUp}
+		delete(row, "id")
+		if train.Site == "" {
+			merged.Dst.Site = site
+			row["site"] = site
+			// The blobs still have to be uploaded under their new names.
+			row["uploaded"] = false
+			row["cleaned_up"] = false
+		}
+
+		q, args := insertQuery("trains_v2", row)
+		err = tx.Get(&merged.Dst.ID, q+" ON CONFLICT DO NOTHING RETURNING id;", args...)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Already exists.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, table := range mergeChildTables {
+			err := mergeChildRows(tx, src, table, train.ID, merged.Dst.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to merge %s: %w", table, err)
+			}
+		}
+
+		err = copyBlobs(merged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy blobs of train %d: %w", train.ID, err)
+		}
+
+		ret = append(ret, merged)
+	}
+
+	return ret, tx.Commit()
+}
+
+// mergeChildRows copies the rows of table which belong to train srcID in src, to train dstID in tx.
+func mergeChildRows(tx *sqlx.Tx, src *sqlx.DB, table string, srcID, dstID int64) error {
+	// #nosec G202 -- table is one of mergeChildTables.
+	rows, err := src.Queryx("SELECT * FROM "+table+" WHERE train_id = ?;", srcID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := map[string]any{}
+		err := rows.MapScan(row)
+		if err != nil {
+			return err
+		}
+		row["train_id"] = dstID
+
+		q, args := insertQuery(table, row)
+		_, err = tx.Exec(q+";", args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/internal/pkg/stitch"
+)
+
+func mustOpenTestDB(t *testing.T, name string) *sqlx.DB {
+	db, err := Open(filepath.Join(t.TempDir(), name))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func Test_Merge(t *testing.T) {
+	// Recorded without site.
+	a := mustOpenTestDB(t, "a.db")
+	cars := []stitch.Car{{StartM: 0, LengthM: 18.5}, {StartM: 18.5, LengthM: 26.4}}
+	idA0, err := InsertTrain(a, stitch.Train{StartTS: t0, Cars: cars, Quality: stitch.Quality{InlierFrac: 0.8}})
+	require.NoError(t, err)
+	_, err = InsertTrain(a, stitch.Train{StartTS: t1, NFrames: 20})
+	require.NoError(t, err)
+	require.NoError(t, SetUploaded(a, idA0))
+	_, err = AddTags(a, []TrainTag{{idA0, "ICN"}})
+	require.NoError(t, err)
+
+	// Recorded with site.
+	b := mustOpenTestDB(t, "b.db")
+	idB0, err := InsertTrain(b, stitch.Train{Site: "b-cam", StartTS: t0})
+	require.NoError(t, err)
+	require.NoError(t, SetUploaded(b, idB0))
+	require.NoError(t, SetCleanedUp(b, idB0))
+
+	dst := mustOpenTestDB(t, "dst.db")
+	_, err = InsertTrain(dst, stitch.Train{Site: "c", StartTS: t2})
+	require.NoError(t, err)
+
+	var copied []MergedTrain
+	copyBlobs := func(m MergedTrain) error {
+		copied = append(copied, m)
+		return nil
+	}
+
+	// Failing to copy blobs aborts the merge.
+	_, err = Merge(dst, a, "a", func(MergedTrain) error { return errors.New("failed") })
+	assert.Error(t, err)
+
+	merged, err := Merge(dst, a, "a", copyBlobs)
+	require.NoError(t, err)
+	require.Len(t, merged, 2)
+	assert.Equal(t, merged, copied)
+	assert.Equal(t, Train{ID: idA0, Site: "", StartTS: t0}, merged[0].Src)
+	assert.Equal(t, "a", merged[0].Dst.Site)
+	assert.True(t, merged[0].SiteAssigned())
+	assert.False(t, merged[0].CleanedUp)
+	assert.Equal(t, "train_20230610_162058.805_+02:00.jpg", merged[0].Src.ImgFileName())
+	merged0ImgFileName := merged[0].Dst.ImgFileName()
+	assert.Equal(t, "train_a_20230610_162058.805_+02:00.jpg", merged0ImgFileName)
+
+	merged, err = Merge(dst, b, "other", copyBlobs)
+	require.NoError(t, err)
+	require.Len(t, merged, 1)
+	assert.Equal(t, "b-cam", merged[0].Dst.Site)
+	assert.False(t, merged[0].SiteAssigned())
+	assert.True(t, merged[0].CleanedUp)
+
+	// Merging again does not change anything.
+	merged, err = Merge(dst, a, "a", copyBlobs)
+	require.NoError(t, err)
+	assert.Empty(t, merged)
+
+	var trains []struct {
+		ID        int64  `db:"id"`
+		Site      string `db:"site"`
+		NFrames   int    `db:"n_frames"`
+		Uploaded  bool   `db:"uploaded"`
+		CleanedUp bool   `db:"cleaned_up"`
+	}
+	err = dst.Select(&trains, "SELECT id, site, n_frames, uploaded, cleaned_up FROM trains_v2 ORDER BY id ASC")
+	require.NoError(t, err)
+	require.Len(t, trains, 4)
+	assert.Equal(t, []string{"c", "a", "a", "b-cam"}, []string{trains[0].Site, trains[1].Site, trains[2].Site, trains[3].Site})
+	assert.Equal(t, 20, trains[2].NFrames)
+	// Blob names changed, so they have to be uploaded again.
+	assert.False(t, trains[1].Uploaded)
+	// Blob names did not change.
+	assert.True(t, trains[3].Uploaded)
+	assert.True(t, trains[3].CleanedUp)
+
+	// Timestamps are preserved.
+	blobs, err := GetAllBlobs(dst)
+	require.NoError(t, err)
+	assert.Contains(t, blobs, merged0ImgFileName)
+
+	// Related rows are copied as well.
+	stored, err := GetCars(dst, trains[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, cars, stored)
+	var inlierFrac float64
+	err = dst.Get(&inlierFrac, "SELECT inlier_frac FROM trains_v2_quality WHERE train_id = ?", trains[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0.8, inlierFrac)
+	tags, err := GetTags(dst, trains[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ICN"}, tags)
+
+	_, err = Merge(dst, a, "Invalid Site", copyBlobs)
+	assert.Error(t, err)
+}
+
+func Test_insertQuery(t *testing.T) {
+	q, args := insertQuery("t", map[string]any{"b": 2, "a": "x"})
+	assert.Equal(t, `INSERT INTO t ("a", "b") VALUES (?, ?)`, q)
+	assert.Equal(t, []any{"x", 2}, args)
+}
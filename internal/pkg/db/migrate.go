@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// Schema migrations, named NNNN_description.sql, where NNNN is the version they migrate to.
+// The schema version of a database is tracked via PRAGMA user_version.
+//
+// Before versioning was introduced, a single idempotent schema script was run every time a database was opened.
+// Such databases have version 0, but might already contain tables from any of the migrations up to 0004,
+// which are thus idempotent (IF NOT EXISTS etc). Later migrations are run exactly once, and need not be.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d{4})_[a-z0-9_]+\.sql$`)
+
+// migration is a single schema migration.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads all migrations from dir in fsys, ordered by version.
+// Versions must start at 1 and be consecutive.
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []migration
+	for _, e := range entries {
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name '%s'", e.Name())
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, err
+		}
+		sql, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, migration{version, e.Name(), string(sql)})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].version < ret[j].version
+	})
+	for i, m := range ret {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration '%s' has version %d, expected %d", m.name, m.version, i+1)
+		}
+	}
+
+	return ret, nil
+}
+
+// schemaVersion returns the schema version of a database.
+func schemaVersion(db sqlx.Queryer) (int, error) {
+	var version int
+	err := sqlx.Get(db, &version, "PRAGMA user_version;")
+	return version, err
+}
+
+// migrate brings the schema of a database up to date, running each missing migration in its own transaction.
+func migrate(db *sqlx.DB, migrations []migration) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	for _, m := range migrations[version:] {
+		err := runMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("failed to run migration '%s': %w", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func runMigration(db *sqlx.DB, m migration) error {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Some schema changes require recreating tables, which would trigger ON DELETE actions of foreign keys.
+	// Foreign keys can only be disabled outside of a transaction, and are checked before commit instead.
+	// See https://www.sqlite.org/lang_altertable.html#otheralter.
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF;")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys = ON;")
+	}()
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// No-op after a successful commit.
+		_ = tx.Rollback()
+	}()
+
+	// Another process might have migrated the database in the meantime.
+	version, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if version >= m.version {
+		return nil
+	}
+
+	log.Info().Str("name", m.name).Msg("running database migration")
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return err
+	}
+
+	var violations []struct {
+		Table  string        `db:"table"`
+		RowID  sql.NullInt64 `db:"rowid"`
+		Parent string        `db:"parent"`
+		FKID   int           `db:"fkid"`
+	}
+	err = tx.Select(&violations, "PRAGMA foreign_key_check;")
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("migration violates %d foreign key constraints, first in table '%s'", len(violations), violations[0].Table)
+	}
+
+	// PRAGMA does not support placeholders.
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", m.version))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
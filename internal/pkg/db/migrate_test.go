@@ -0,0 +1,183 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/internal/pkg/stitch"
+)
+
+func Test_loadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+		assert.NotEmpty(t, m.sql)
+	}
+
+	// Sorted by version.
+	migrations, err = loadMigrations(fstest.MapFS{
+		"m/0002_b.sql": {Data: []byte("SELECT 2;")},
+		"m/0001_a.sql": {Data: []byte("SELECT 1;")},
+	}, "m")
+	require.NoError(t, err)
+	assert.Equal(t, []migration{{1, "0001_a.sql", "SELECT 1;"}, {2, "0002_b.sql", "SELECT 2;"}}, migrations)
+
+	// Gap.
+	_, err = loadMigrations(fstest.MapFS{
+		"m/0001_a.sql": {Data: []byte("SELECT 1;")},
+		"m/0003_c.sql": {Data: []byte("SELECT 3;")},
+	}, "m")
+	assert.Error(t, err)
+
+	// Invalid name.
+	_, err = loadMigrations(fstest.MapFS{
+		"m/1_a.sql": {Data: []byte("SELECT 1;")},
+	}, "m")
+	assert.Error(t, err)
+}
+
+func mustSchemaVersion(t *testing.T, db *sqlx.DB) int {
+	version, err := schemaVersion(db)
+	require.NoError(t, err)
+	return version
+}
+
+func Test_Migrate_V1Fixture(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	require.NoError(t, err)
+
+	// Create the fixture database, without running any migrations.
+	dbpath := filepath.Join(t.TempDir(), "v1.db")
+	fixture, err := os.ReadFile("testdata/v1.sql")
+	require.NoError(t, err)
+	raw, err := sqlx.Open(driver, buildDSN(dbpath, false))
+	require.NoError(t, err)
+	_, err = raw.Exec(string(fixture))
+	require.NoError(t, err)
+	assert.Equal(t, 0, mustSchemaVersion(t, raw))
+	require.NoError(t, raw.Close())
+
+	// Upgrade to head.
+	db, err := Open(dbpath)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, len(migrations), mustSchemaVersion(t, db))
+
+	var trains []struct {
+		ID        int64   `db:"id"`
+		LengthPx  float64 `db:"length_px"`
+		Uploaded  bool    `db:"uploaded"`
+		CleanedUp bool    `db:"cleaned_up"`
+	}
+	err = db.Select(&trains, "SELECT id, length_px, uploaded, cleaned_up FROM trains_v2 ORDER BY id ASC")
+	require.NoError(t, err)
+	require.Len(t, trains, 3)
+	assert.Equal(t, 2950.5, trains[0].LengthPx)
+	assert.True(t, trains[0].Uploaded)
+	assert.True(t, trains[0].CleanedUp)
+	assert.True(t, trains[1].Uploaded)
+	assert.False(t, trains[1].CleanedUp)
+	assert.False(t, trains[2].Uploaded)
+
+	// Backfilled, as far as possible.
+	var backfilled struct {
+		Direction string          `db:"direction"`
+		DurationS float64         `db:"duration_s"`
+		EndTS     time.Time       `db:"end_ts"`
+		Estimated bool            `db:"timing_estimated"`
+		FPS       sql.NullFloat64 `db:"fps"`
+	}
+	err = db.Get(&backfilled, "SELECT direction, duration_s, end_ts, timing_estimated, fps FROM trains_v2 WHERE id = 1")
+	require.NoError(t, err)
+	assert.Equal(t, "left", backfilled.Direction)
+	assert.InDelta(t, 2950.5/480.2, backfilled.DurationS, 1e-9)
+	assert.WithinDuration(t, t0.Add(time.Duration(backfilled.DurationS*float64(time.Second))), backfilled.EndTS, time.Millisecond)
+	assert.True(t, backfilled.Estimated)
+	assert.False(t, backfilled.FPS.Valid)
+
+	// Old tables are emptied.
+	var n int
+	require.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM trains"))
+	assert.Zero(t, n)
+	require.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM temperatures"))
+	assert.Zero(t, n)
+
+	// The upgraded database is fully usable.
+	next, err := GetNextUpload(db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), next.ID)
+	assert.Equal(t, t2, next.StartTS)
+	id, err := InsertTrain(db, stitch.Train{StartTS: t3, Cars: []stitch.Car{{StartM: 0, LengthM: 20}}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), id)
+
+	// Opening again does not change anything.
+	require.NoError(t, db.Close())
+	db, err = Open(dbpath)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), mustSchemaVersion(t, db))
+	require.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM trains_v2"))
+	assert.Equal(t, 4, n)
+}
+
+func Test_Migrate_Unversioned(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	require.NoError(t, err)
+
+	// Databases created before versioning have the schema up to 0004, but version 0.
+	dbpath := filepath.Join(t.TempDir(), "test.db")
+	db, err := sqlx.Open(driver, buildDSN(dbpath, false))
+	require.NoError(t, err)
+	require.NoError(t, migrate(db, migrations[:4]))
+	_, err = db.Exec("INSERT INTO trains_v2 (start_ts, n_frames, length_px, speed_px_s, accel_px_s_2, px_per_m) VALUES (?, 10, 900, 300, 0, 45);", t0)
+	require.NoError(t, err)
+	_, err = db.Exec("PRAGMA user_version = 0;")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(dbpath)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, len(migrations), mustSchemaVersion(t, db))
+	var n int
+	require.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM trains_v2"))
+	assert.Equal(t, 1, n)
+}
+
+func Test_Migrate_TooNew(t *testing.T) {
+	dbpath := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(dbpath)
+	require.NoError(t, err)
+	_, err = db.Exec("PRAGMA user_version = 9999;")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = Open(dbpath)
+	assert.Error(t, err)
+}
+
+func Test_Migrate_Failure(t *testing.T) {
+	db, err := sqlx.Open(driver, buildDSN(filepath.Join(t.TempDir(), "test.db"), false))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// A failing migration is rolled back completely.
+	err = migrate(db, []migration{
+		{1, "0001_a.sql", "CREATE TABLE a (x INT);"},
+		{2, "0002_b.sql", "CREATE TABLE b (x INT); INSERT INTO nonexistent VALUES (1);"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, mustSchemaVersion(t, db))
+	var n int
+	require.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'"))
+	assert.Zero(t, n)
+}
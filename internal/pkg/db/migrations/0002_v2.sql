@@ -0,0 +1,43 @@
+-- Schema v2!
+
+CREATE TABLE IF NOT EXISTS trains_v2 (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    start_ts DATETIME NOT NULL UNIQUE,
+
+    n_frames INT NOT NULL,
+    -- Always positive (absolute value).
+    length_px DOUBLE NOT NULL,
+    -- Positive sign means movement to the right, negative to the left.
+    speed_px_s DOUBLE NOT NULL,
+    -- Positive sign means increasing speed for trains going to the right, breaking for trains going to the left.
+    accel_px_s_2 DOUBLE NOT NULL,
+    px_per_m  DOUBLE NOT NULL,
+
+    -- Files from blob dir were uploaded.
+    uploaded BOOL NOT NULL DEFAULT FALSE,
+
+    -- Blobs we have deleted locally after upload.
+    cleaned_up BOOL NOT NULL DEFAULT FALSE
+);
+
+-- Move train sightings from the v1 tables.
+INSERT INTO trains_v2
+SELECT
+    id,
+    start_ts,
+    n_frames,
+    length_px,
+    speed_px_s,
+    accel_px_s_2,
+    px_per_m,
+    uploaded_at IS NOT NULL,
+    trains_blob_cleanups.cleaned_up_at IS NOT NULL
+FROM trains
+LEFT JOIN trains_blob_cleanups ON trains_blob_cleanups.train_id = trains.id
+ORDER BY id ASC;
+
+-- Truncate old tables.
+DELETE FROM trains_blob_cleanups;
+DELETE FROM trains;
+DELETE FROM temperatures;
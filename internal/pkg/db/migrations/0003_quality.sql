@@ -0,0 +1,18 @@
+-- Quality of train sightings, see stitch.Quality.
+CREATE TABLE IF NOT EXISTS trains_v2_quality (
+    train_id INTEGER PRIMARY KEY,
+
+    inlier_frac DOUBLE NOT NULL,
+    fit_residual_px_s DOUBLE NOT NULL,
+    mean_score DOUBLE NOT NULL,
+    min_score DOUBLE NOT NULL,
+    contrast DOUBLE NOT NULL,
+    jitter_px DOUBLE NOT NULL,
+    -- Summary score, see stitch.Quality.Score().
+    score DOUBLE NOT NULL,
+
+    -- Quality below threshold, the train is kept but not uploaded.
+    flagged BOOL NOT NULL DEFAULT FALSE,
+
+    FOREIGN KEY(train_id) REFERENCES trains_v2(id) ON DELETE CASCADE
+);
@@ -0,0 +1,23 @@
+-- Cars (vehicles) segmented from the images of train sightings, see stitch.Car.
+-- Trains without a row here have not been segmented.
+CREATE TABLE IF NOT EXISTS trains_v2_car_counts (
+    train_id INTEGER PRIMARY KEY,
+
+    n_cars INT NOT NULL,
+
+    FOREIGN KEY(train_id) REFERENCES trains_v2(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS trains_v2_cars (
+    train_id INTEGER NOT NULL,
+    -- Left to right in the image, starting at 0.
+    car_ix INT NOT NULL,
+
+    -- Position of the left edge of the car, from the left edge of the image.
+    start_m DOUBLE NOT NULL,
+    -- Always positive.
+    length_m DOUBLE NOT NULL,
+
+    PRIMARY KEY(train_id, car_ix),
+    FOREIGN KEY(train_id) REFERENCES trains_v2(id) ON DELETE CASCADE
+);
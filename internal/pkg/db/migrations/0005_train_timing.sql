@@ -0,0 +1,33 @@
+-- End timestamp, duration, frame rate, direction and capture rect of train sightings.
+
+-- Timestamp of the last frame.
+ALTER TABLE trains_v2 ADD COLUMN end_ts DATETIME NULL DEFAULT NULL;
+ALTER TABLE trains_v2 ADD COLUMN duration_s DOUBLE NULL DEFAULT NULL;
+-- Mean frame rate.
+ALTER TABLE trains_v2 ADD COLUMN fps DOUBLE NULL DEFAULT NULL;
+-- Either 'left' or 'right'.
+ALTER TABLE trains_v2 ADD COLUMN direction TEXT NULL DEFAULT NULL;
+
+-- Region of the camera picture which was processed.
+ALTER TABLE trains_v2 ADD COLUMN rect_x INT NULL DEFAULT NULL;
+ALTER TABLE trains_v2 ADD COLUMN rect_y INT NULL DEFAULT NULL;
+ALTER TABLE trains_v2 ADD COLUMN rect_w INT NULL DEFAULT NULL;
+ALTER TABLE trains_v2 ADD COLUMN rect_h INT NULL DEFAULT NULL;
+
+-- End timestamp and duration were not recorded, but estimated from length and speed.
+ALTER TABLE trains_v2 ADD COLUMN timing_estimated BOOL NOT NULL DEFAULT FALSE;
+
+-- Backfill existing train sightings, as far as possible.
+-- Frame rate and rect were never recorded for them, the end timestamps of schema v1 were dropped by the migration to v2.
+UPDATE trains_v2
+SET direction = CASE WHEN speed_px_s > 0 THEN 'right' ELSE 'left' END;
+
+UPDATE trains_v2
+SET
+    duration_s = length_px / ABS(speed_px_s),
+    timing_estimated = TRUE
+WHERE speed_px_s != 0;
+
+UPDATE trains_v2
+SET end_ts = strftime('%Y-%m-%dT%H:%M:%fZ', julianday(start_ts) + duration_s / 86400)
+WHERE duration_s IS NOT NULL;
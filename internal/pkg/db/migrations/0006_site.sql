@@ -0,0 +1,89 @@
+-- Site/camera identifier of train sightings, so that databases from multiple sites can be combined.
+-- The start timestamp is now only unique per site, which requires recreating the table.
+
+CREATE TABLE trains_v2_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    -- Site/camera identifier, empty for a single site.
+    site TEXT NOT NULL DEFAULT '',
+    start_ts DATETIME NOT NULL,
+
+    n_frames INT NOT NULL,
+    -- Always positive (absolute value).
+    length_px DOUBLE NOT NULL,
+    -- Positive sign means movement to the right, negative to the left.
+    speed_px_s DOUBLE NOT NULL,
+    -- Positive sign means increasing speed for trains going to the right, breaking for trains going to the left.
+    accel_px_s_2 DOUBLE NOT NULL,
+    px_per_m  DOUBLE NOT NULL,
+
+    -- Files from blob dir were uploaded.
+    uploaded BOOL NOT NULL DEFAULT FALSE,
+
+    -- Blobs we have deleted locally after upload.
+    cleaned_up BOOL NOT NULL DEFAULT FALSE,
+
+    -- Timestamp of the last frame.
+    end_ts DATETIME NULL DEFAULT NULL,
+    duration_s DOUBLE NULL DEFAULT NULL,
+    -- Mean frame rate.
+    fps DOUBLE NULL DEFAULT NULL,
+    -- Either 'left' or 'right'.
+    direction TEXT NULL DEFAULT NULL,
+
+    -- Region of the camera picture which was processed.
+    rect_x INT NULL DEFAULT NULL,
+    rect_y INT NULL DEFAULT NULL,
+    rect_w INT NULL DEFAULT NULL,
+    rect_h INT NULL DEFAULT NULL,
+
+    -- End timestamp and duration were not recorded, but estimated from length and speed.
+    timing_estimated BOOL NOT NULL DEFAULT FALSE,
+
+    UNIQUE(site, start_ts)
+);
+
+INSERT INTO trains_v2_new (
+    id,
+    start_ts,
+    n_frames,
+    length_px,
+    speed_px_s,
+    accel_px_s_2,
+    px_per_m,
+    uploaded,
+    cleaned_up,
+    end_ts,
+    duration_s,
+    fps,
+    direction,
+    rect_x,
+    rect_y,
+    rect_w,
+    rect_h,
+    timing_estimated
+)
+SELECT
+    id,
+    start_ts,
+    n_frames,
+    length_px,
+    speed_px_s,
+    accel_px_s_2,
+    px_per_m,
+    uploaded,
+    cleaned_up,
+    end_ts,
+    duration_s,
+    fps,
+    direction,
+    rect_x,
+    rect_y,
+    rect_w,
+    rect_h,
+    timing_estimated
+FROM trains_v2
+ORDER BY id ASC;
+
+DROP TABLE trains_v2;
+ALTER TABLE trains_v2_new RENAME TO trains_v2;
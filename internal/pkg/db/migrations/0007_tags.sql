@@ -0,0 +1,11 @@
+-- Manually applied labels of train sightings, e.g. operator, train type or 'false-positive'.
+CREATE TABLE trains_v2_tags (
+    train_id INTEGER NOT NULL,
+    -- See ValidateTag().
+    tag TEXT NOT NULL,
+
+    PRIMARY KEY(train_id, tag),
+    FOREIGN KEY(train_id) REFERENCES trains_v2(id) ON DELETE CASCADE
+);
+
+CREATE INDEX trains_v2_tags_tag ON trains_v2_tags(tag);
@@ -3,69 +3,183 @@ package db
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"jo-m.ch/go/trainbot/internal/pkg/stitch"
 )
 
-// InsertTrain inserts a new train sighting into the database.
+// InsertTrain inserts a new train sighting, including its quality and cars, into the database.
 // Returns the db id of the new row.
 func InsertTrain(db *sqlx.DB, t stitch.Train) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// No-op after a successful commit.
+		_ = tx.Rollback()
+	}()
+
 	var id int64
 	const q = `
 	INSERT INTO trains_v2 (
+		site,
 		start_ts,
+		end_ts,
+		duration_s,
 		n_frames,
+		fps,
 		length_px,
 		speed_px_s,
 		accel_px_s_2,
-		px_per_m
+		direction,
+		px_per_m,
+		rect_x,
+		rect_y,
+		rect_w,
+		rect_h
 	)
-	VALUES (?, ?, ?, ?, ?, ?)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	RETURNING id;`
-	err := db.Get(&id, q,
+	rect := t.Conf.Rect
+	err = tx.Get(&id, q,
+		t.Site,
 		t.StartTS,
+		t.EndTS,
+		t.DurationS(),
 		t.NFrames,
+		t.FPS,
 		t.LengthPx,
 		t.SpeedPxS,
 		t.AccelPxS2,
-		t.Conf.PixelsPerM)
+		t.DirectionS(),
+		t.Conf.PixelsPerM,
+		rect.Min.X,
+		rect.Min.Y,
+		rect.Dx(),
+		rect.Dy())
 	if err != nil {
 		return 0, err
 	}
 
-	return id, nil
+	const qQuality = `
+	INSERT INTO trains_v2_quality (
+		train_id,
+		inlier_frac,
+		fit_residual_px_s,
+		mean_score,
+		min_score,
+		contrast,
+		jitter_px,
+		score,
+		flagged
+	)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(qQuality,
+		id,
+		t.Quality.InlierFrac,
+		t.Quality.FitResidualPxS,
+		t.Quality.MeanScore,
+		t.Quality.MinScore,
+		t.Quality.Contrast,
+		t.Quality.JitterPx,
+		t.Quality.Score(),
+		t.Flagged)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertCars(tx, id, t.Cars)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// insertCars inserts the car count and cars of a train. Does nothing if there are no cars.
+func insertCars(tx *sqlx.Tx, trainID int64, cars []stitch.Car) error {
+	if len(cars) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec("INSERT INTO trains_v2_car_counts (train_id, n_cars) VALUES (?, ?);", trainID, len(cars))
+	if err != nil {
+		return err
+	}
+
+	const q = `
+	INSERT INTO trains_v2_cars (
+		train_id,
+		car_ix,
+		start_m,
+		length_m
+	)
+	VALUES (?, ?, ?, ?);`
+	for i, c := range cars {
+		_, err = tx.Exec(q, trainID, i, c.StartM, c.LengthM)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // This should have been ".000_-07:00"... but it's too late now.
 const fileTSFormat = "20060102_150405.999_Z07:00"
 
+var siteRe = regexp.MustCompile(`^([a-z0-9][a-z0-9-]*)?$`)
+
+// ValidateSite checks if site is a valid site/camera identifier:
+// Either empty, or lower case letters, digits and dashes, not starting with a dash.
+func ValidateSite(site string) error {
+	if !siteRe.MatchString(site) {
+		return fmt.Errorf("invalid site '%s', must only contain lower case letters, digits and dashes", site)
+	}
+	return nil
+}
+
 // Train represents the basics of a train in the database.
 type Train struct {
 	ID      int64     `db:"id"`
+	Site    string    `db:"site"`
 	StartTS time.Time `db:"start_ts"`
 }
 
-// GIFFileName returns the GIF file name for this train (derived from timestamp).
-func (t *Train) GIFFileName() string {
+// blobBaseName returns the file name of blobs for this train, without extension (derived from site and timestamp).
+// Trains without site keep the names from before sites were introduced.
+func (t *Train) blobBaseName() string {
 	tsString := t.StartTS.Format(fileTSFormat)
-	return fmt.Sprintf("train_%s.gif", tsString)
+	if t.Site == "" {
+		return fmt.Sprintf("train_%s", tsString)
+	}
+	return fmt.Sprintf("train_%s_%s", t.Site, tsString)
 }
 
-// ImgFileName returns the image file name for this train (derived from timestamp).
+// GIFFileName returns the GIF file name for this train (derived from site and timestamp).
+func (t *Train) GIFFileName() string {
+	return t.blobBaseName() + ".gif"
+}
+
+// ImgFileName returns the image file name for this train (derived from site and timestamp).
 func (t *Train) ImgFileName() string {
-	tsString := t.StartTS.Format(fileTSFormat)
-	return fmt.Sprintf("train_%s.jpg", tsString)
+	return t.blobBaseName() + ".jpg"
 }
 
 // GetNextUpload returns the next train sighting to upload from the database.
+// Flagged train sightings are never uploaded.
 func GetNextUpload(db *sqlx.DB) (*Train, error) {
 	const q = `
 	SELECT
-		id, start_ts
+		id, site, start_ts
 	FROM trains_v2
-	WHERE NOT uploaded
+	LEFT JOIN trains_v2_quality ON trains_v2_quality.train_id = trains_v2.id
+	WHERE
+		NOT uploaded
+		AND NOT COALESCE(trains_v2_quality.flagged, FALSE)
 	ORDER BY id ASC
 	LIMIT 1;
 	`
@@ -78,6 +192,33 @@ func GetNextUpload(db *sqlx.DB) (*Train, error) {
 	return &ret, nil
 }
 
+// GetCars returns the cars of a train sighting, left to right.
+// Returns an empty slice if the train has not been segmented.
+func GetCars(db *sqlx.DB, trainID int64) ([]stitch.Car, error) {
+	const q = `
+	SELECT
+		start_m, length_m
+	FROM trains_v2_cars
+	WHERE train_id = ?
+	ORDER BY car_ix ASC;
+	`
+
+	var rows []struct {
+		StartM  float64 `db:"start_m"`
+		LengthM float64 `db:"length_m"`
+	}
+	err := db.Select(&rows, q, trainID)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]stitch.Car, len(rows))
+	for i, r := range rows {
+		ret[i] = stitch.Car{StartM: r.StartM, LengthM: r.LengthM}
+	}
+	return ret, nil
+}
+
 // ErrNoRowAffected means that a row was expected to change - but none did.
 var ErrNoRowAffected = errors.New("no rows affected")
 
@@ -111,7 +252,7 @@ func GetNextCleanup(db *sqlx.DB) (*Train, error) {
 
 	const q = `
 	SELECT
-		id, start_ts
+		id, site, start_ts
 	FROM trains_v2
 	WHERE
 		uploaded
@@ -154,12 +295,31 @@ func SetCleanedUp(db *sqlx.DB, id int64) error {
 	return err
 }
 
+// DeleteFlagged deletes all flagged train sightings from the database.
+// Meant to be run on a copy of the database before publishing it.
+// Returns the number of deleted rows.
+func DeleteFlagged(db *sqlx.DB) (int64, error) {
+	const q = `
+	DELETE FROM trains_v2
+	WHERE id IN (
+		SELECT train_id
+		FROM trains_v2_quality
+		WHERE flagged
+	);`
+	res, err := db.Exec(q)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
 // GetAllBlobs lists all blobs which the database knows about.
 // Does not include thumbnails.
 func GetAllBlobs(db *sqlx.DB) (map[string]struct{}, error) {
 	const q = `
 	SELECT
-		id, start_ts
+		id, site, start_ts
 	FROM trains_v2;`
 
 	rows, err := db.Queryx(q)
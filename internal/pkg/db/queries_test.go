@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"image"
 	"path/filepath"
 	"testing"
 	"time"
@@ -57,6 +58,13 @@ func Test_Train_Filenames(t *testing.T) {
 	}
 	assert.Equal(t, "train_20230328_063216.516_+01:00.jpg", tr.ImgFileName())
 	assert.Equal(t, "train_20230328_063216.516_+01:00.gif", tr.GIFFileName())
+
+	tr = Train{
+		Site:    "bern-1",
+		StartTS: mustParseTime("2023-03-28T06:32:16.516941205+01:00"),
+	}
+	assert.Equal(t, "train_bern-1_20230328_063216.516_+01:00.jpg", tr.ImgFileName())
+	assert.Equal(t, "train_bern-1_20230328_063216.516_+01:00.gif", tr.GIFFileName())
 }
 
 func Test_Train_Queries(t *testing.T) {
@@ -162,3 +170,168 @@ func Test_Train_TimesstampDBSerialization(t *testing.T) {
 	assert.Len(t, results, 2)
 	assert.Equal(t, "2023-11-10T12:57:45.897+01:00", results[0].StartTS)
 }
+
+func Test_Train_Quality(t *testing.T) {
+	tmp := t.TempDir()
+	dbpath := filepath.Join(tmp, "test.db")
+	db, err := Open(dbpath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	q := stitch.Quality{
+		InlierFrac:     0.9,
+		FitResidualPxS: 1.5,
+		MeanScore:      0.98,
+		MinScore:       0.93,
+		Contrast:       0.1,
+		JitterPx:       0.7,
+	}
+	idFlagged, err := InsertTrain(db, stitch.Train{StartTS: t0, Quality: q, Flagged: true})
+	require.NoError(t, err)
+	id, err := InsertTrain(db, stitch.Train{StartTS: t1, Quality: q})
+	require.NoError(t, err)
+
+	var stored struct {
+		InlierFrac float64 `db:"inlier_frac"`
+		JitterPx   float64 `db:"jitter_px"`
+		Score      float64 `db:"score"`
+		Flagged    bool    `db:"flagged"`
+	}
+	err = db.Get(&stored, "SELECT inlier_frac, jitter_px, score, flagged FROM trains_v2_quality WHERE train_id = ?", idFlagged)
+	require.NoError(t, err)
+	assert.Equal(t, 0.9, stored.InlierFrac)
+	assert.Equal(t, 0.7, stored.JitterPx)
+	assert.Equal(t, q.Score(), stored.Score)
+	assert.True(t, stored.Flagged)
+
+	// Flagged trains are not uploaded.
+	upl, err := GetNextUpload(db)
+	require.NoError(t, err)
+	assert.Equal(t, id, upl.ID)
+	require.NoError(t, SetUploaded(db, id))
+	_, err = GetNextUpload(db)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// But can be deleted.
+	n, err := DeleteFlagged(db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	var count int
+	require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM trains_v2"))
+	assert.Equal(t, 1, count)
+	require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM trains_v2_quality"))
+	assert.Equal(t, 1, count)
+}
+
+func Test_Train_Cars(t *testing.T) {
+	tmp := t.TempDir()
+	dbpath := filepath.Join(tmp, "test.db")
+	db, err := Open(dbpath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	cars := []stitch.Car{{StartM: 0, LengthM: 18.5}, {StartM: 18.5, LengthM: 26.4}}
+	id, err := InsertTrain(db, stitch.Train{StartTS: t0, Cars: cars})
+	require.NoError(t, err)
+	idNone, err := InsertTrain(db, stitch.Train{StartTS: t1})
+	require.NoError(t, err)
+
+	stored, err := GetCars(db, id)
+	require.NoError(t, err)
+	assert.Equal(t, cars, stored)
+	var n int
+	err = db.Get(&n, "SELECT n_cars FROM trains_v2_car_counts WHERE train_id = ?", id)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// Not segmented.
+	stored, err = GetCars(db, idNone)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+	err = db.Get(&n, "SELECT n_cars FROM trains_v2_car_counts WHERE train_id = ?", idNone)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// Deleted together with the train.
+	_, err = db.Exec("DELETE FROM trains_v2 WHERE id = ?", id)
+	require.NoError(t, err)
+	stored, err = GetCars(db, id)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func Test_Train_Timing(t *testing.T) {
+	tmp := t.TempDir()
+	dbpath := filepath.Join(tmp, "test.db")
+	db, err := Open(dbpath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	id, err := InsertTrain(db, stitch.Train{
+		StartTS:  t0,
+		EndTS:    t0.Add(time.Millisecond * 6500),
+		NFrames:  195,
+		FPS:      30,
+		SpeedPxS: -300,
+		Conf:     stitch.Config{PixelsPerM: 45, Rect: image.Rect(10, 20, 310, 220)},
+	})
+	require.NoError(t, err)
+
+	var stored struct {
+		EndTS     time.Time `db:"end_ts"`
+		DurationS float64   `db:"duration_s"`
+		FPS       float64   `db:"fps"`
+		Direction string    `db:"direction"`
+		RectX     int       `db:"rect_x"`
+		RectY     int       `db:"rect_y"`
+		RectW     int       `db:"rect_w"`
+		RectH     int       `db:"rect_h"`
+		Estimated bool      `db:"timing_estimated"`
+	}
+	err = db.Get(&stored, `
+	SELECT end_ts, duration_s, fps, direction, rect_x, rect_y, rect_w, rect_h, timing_estimated
+	FROM trains_v2 WHERE id = ?`, id)
+	require.NoError(t, err)
+	assert.True(t, t0.Add(time.Millisecond*6500).Equal(stored.EndTS))
+	assert.Equal(t, 6.5, stored.DurationS)
+	assert.Equal(t, 30., stored.FPS)
+	assert.Equal(t, "left", stored.Direction)
+	assert.Equal(t, []int{10, 20, 300, 200}, []int{stored.RectX, stored.RectY, stored.RectW, stored.RectH})
+	assert.False(t, stored.Estimated)
+}
+
+func Test_ValidateSite(t *testing.T) {
+	for _, site := range []string{"", "a", "bern-1", "0-zurich"} {
+		assert.NoError(t, ValidateSite(site), site)
+	}
+	for _, site := range []string{"-a", "Bern", "a_b", "a/b", "a b", "ü"} {
+		assert.Error(t, ValidateSite(site), site)
+	}
+}
+
+func Test_Train_Site(t *testing.T) {
+	tmp := t.TempDir()
+	dbpath := filepath.Join(tmp, "test.db")
+	db, err := Open(dbpath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The same start timestamp may occur once per site.
+	_, err = InsertTrain(db, stitch.Train{StartTS: t0})
+	require.NoError(t, err)
+	_, err = InsertTrain(db, stitch.Train{Site: "a", StartTS: t0})
+	require.NoError(t, err)
+	_, err = InsertTrain(db, stitch.Train{Site: "b", StartTS: t0})
+	require.NoError(t, err)
+	_, err = InsertTrain(db, stitch.Train{Site: "b", StartTS: t0})
+	assert.Error(t, err)
+
+	upl, err := GetNextUpload(db)
+	require.NoError(t, err)
+	assert.Equal(t, "", upl.Site)
+
+	blobs, err := GetAllBlobs(db)
+	require.NoError(t, err)
+	assert.Len(t, blobs, 6)
+	assert.Contains(t, blobs, "train_b_20230610_162058.805_+02:00.jpg")
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const maxTagLen = 64
+
+// ValidateTag checks if tag is a valid train sighting tag:
+// 1 to 64 characters, no commas or control characters, and no leading or trailing whitespace.
+// Tags are case sensitive.
+func ValidateTag(tag string) error {
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLen {
+		return fmt.Errorf("invalid tag '%s', must have 1 to %d characters", tag, maxTagLen)
+	}
+	if strings.TrimSpace(tag) != tag {
+		return fmt.Errorf("invalid tag '%s', must not start or end with whitespace", tag)
+	}
+	if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+		return fmt.Errorf("invalid tag '%s', must not contain commas or control characters", tag)
+	}
+	return nil
+}
+
+// TrainTag is a tag applied to a train sighting.
+type TrainTag struct {
+	TrainID int64  `db:"train_id"`
+	Tag     string `db:"tag"`
+}
+
+// TagCount is a tag and the number of train sightings it is applied to.
+type TagCount struct {
+	Tag     string `db:"tag"`
+	NTrains int64  `db:"n_trains"`
+}
+
+// AddTags applies tags to train sightings, in a single transaction.
+// Tags which are already applied are ignored.
+// Fails if any of the tags is invalid or any of the train sightings does not exist.
+// Returns the number of newly applied tags.
+func AddTags(db *sqlx.DB, tags []TrainTag) (int64, error) {
+	const q = `
+	INSERT INTO trains_v2_tags (
+		train_id,
+		tag
+	)
+	VALUES (?, ?)
+	ON CONFLICT DO NOTHING;`
+	return execTags(db, q, tags)
+}
+
+// RemoveTags removes tags from train sightings, in a single transaction.
+// Tags which are not applied are ignored.
+// Returns the number of removed tags.
+func RemoveTags(db *sqlx.DB, tags []TrainTag) (int64, error) {
+	const q = `
+	DELETE FROM trains_v2_tags
+	WHERE train_id = ? AND tag = ?;`
+	return execTags(db, q, tags)
+}
+
+// execTags runs q with the train id and tag of each of tags as arguments, and returns the total number of rows affected.
+func execTags(db *sqlx.DB, q string, tags []TrainTag) (int64, error) {
+	for _, t := range tags {
+		err := ValidateTag(t.Tag)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// No-op after a successful commit.
+		_ = tx.Rollback()
+	}()
+
+	var n int64
+	for _, t := range tags {
+		res, err := tx.Exec(q, t.TrainID, t.Tag)
+		if err != nil {
+			return 0, fmt.Errorf("train %d, tag '%s': %w", t.TrainID, t.Tag, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		n += affected
+	}
+
+	return n, tx.Commit()
+}
+
+// GetTags returns the tags applied to a train sighting, sorted alphabetically.
+func GetTags(db *sqlx.DB, trainID int64) ([]string, error) {
+	const q = `
+	SELECT
+		tag
+	FROM trains_v2_tags
+	WHERE train_id = ?
+	ORDER BY tag ASC;
+	`
+
+	ret := []string{}
+	err := db.Select(&ret, q, trainID)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ListTags returns all tags which are applied to at least one train sighting, sorted alphabetically.
+func ListTags(db *sqlx.DB) ([]TagCount, error) {
+	const q = `
+	SELECT
+		tag, COUNT(*) AS n_trains
+	FROM trains_v2_tags
+	GROUP BY tag
+	ORDER BY tag ASC;
+	`
+
+	ret := []TagCount{}
+	err := db.Select(&ret, q)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetTrainsByTag returns all train sightings with a tag, oldest first.
+func GetTrainsByTag(db *sqlx.DB, tag string) ([]Train, error) {
+	const q = `
+	SELECT
+		id, site, start_ts
+	FROM trains_v2
+	JOIN trains_v2_tags ON trains_v2_tags.train_id = trains_v2.id
+	WHERE tag = ?
+	ORDER BY id ASC;
+	`
+
+	ret := []Train{}
+	err := db.Select(&ret, q, tag)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
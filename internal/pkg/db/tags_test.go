@@ -0,0 +1,77 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/internal/pkg/stitch"
+)
+
+func Test_ValidateTag(t *testing.T) {
+	for _, tag := range []string{"ICN", "freight", "false positive", "SBB CFF FFS", "Re 4/4", "a"} {
+		assert.NoError(t, ValidateTag(tag), tag)
+	}
+	for _, tag := range []string{"", " freight", "freight ", "a,b", "a\nb", strings.Repeat("a", 65)} {
+		assert.Error(t, ValidateTag(tag), tag)
+	}
+}
+
+func Test_Tags(t *testing.T) {
+	db := mustOpenTestDB(t, "test.db")
+
+	id0, err := InsertTrain(db, stitch.Train{StartTS: t0})
+	require.NoError(t, err)
+	id1, err := InsertTrain(db, stitch.Train{StartTS: t1})
+	require.NoError(t, err)
+
+	n, err := AddTags(db, []TrainTag{{id0, "ICN"}, {id0, "SBB"}, {id1, "SBB"}, {id1, "SBB"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// Already applied.
+	n, err = AddTags(db, []TrainTag{{id0, "ICN"}})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// Invalid tag, or unknown train: nothing is applied.
+	_, err = AddTags(db, []TrainTag{{id1, "freight"}, {id1, "a,b"}})
+	assert.Error(t, err)
+	_, err = AddTags(db, []TrainTag{{id1, "freight"}, {id1 + 100, "freight"}})
+	assert.Error(t, err)
+
+	tags, err := GetTags(db, id0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ICN", "SBB"}, tags)
+	tags, err = GetTags(db, id1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SBB"}, tags)
+
+	counts, err := ListTags(db)
+	require.NoError(t, err)
+	assert.Equal(t, []TagCount{{"ICN", 1}, {"SBB", 2}}, counts)
+
+	trains, err := GetTrainsByTag(db, "SBB")
+	require.NoError(t, err)
+	require.Len(t, trains, 2)
+	assert.Equal(t, []int64{id0, id1}, []int64{trains[0].ID, trains[1].ID})
+	assert.Equal(t, t1, trains[1].StartTS)
+	trains, err = GetTrainsByTag(db, "freight")
+	require.NoError(t, err)
+	assert.Empty(t, trains)
+
+	n, err = RemoveTags(db, []TrainTag{{id0, "SBB"}, {id0, "freight"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	tags, err = GetTags(db, id0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ICN"}, tags)
+
+	// Removed along with the train.
+	_, err = db.Exec("DELETE FROM trains_v2 WHERE id = ?", id0)
+	require.NoError(t, err)
+	counts, err = ListTags(db)
+	require.NoError(t, err)
+	assert.Equal(t, []TagCount{{"SBB", 1}}, counts)
+}
@@ -0,0 +1,46 @@
+-- A database as written by the first version of trainbot (schema v1), before schema versioning.
+
+CREATE TABLE trains (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    start_ts DATETIME NOT NULL UNIQUE,
+    end_ts DATETIME NOT NULL,
+
+    n_frames INT NOT NULL,
+    length_px DOUBLE NOT NULL,
+    speed_px_s DOUBLE NOT NULL,
+    accel_px_s_2 DOUBLE NOT NULL,
+    px_per_m  DOUBLE NOT NULL,
+
+    image_file_path TEXT NOT NULL UNIQUE,
+    gif_file_path TEXT NOT NULL UNIQUE,
+
+    uploaded_at DATETIME NULL DEFAULT NULL
+);
+
+CREATE INDEX trains_length ON trains(length_px / px_per_m);
+CREATE INDEX trains_speed ON trains(ABS(speed_px_s / px_per_m));
+
+CREATE TABLE trains_blob_cleanups (
+    train_id INTEGER PRIMARY KEY,
+    cleaned_up_at DATETIME NOT NULL,
+    FOREIGN KEY(train_id) REFERENCES trains(id)
+);
+
+CREATE TABLE temperatures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp DATETIME NOT NULL UNIQUE,
+    temp_deg_c DOUBLE NOT NULL
+);
+
+INSERT INTO trains VALUES
+    (1, '2023-06-10 16:20:58.805+02:00', '2023-06-10 16:21:04.112+02:00', 160, 2950.5, -480.2, 1.5, 45,
+        'train_20230610_162058.805_+02:00.jpg', 'train_20230610_162058.805_+02:00.gif', '2023-06-10 16:22:00+02:00'),
+    (2, '2023-06-10 16:21:05.982+02:00', '2023-06-10 16:21:09.001+02:00', 90, 1510, 500.1, -0.5, 45,
+        'train_20230610_162105.982_+02:00.jpg', 'train_20230610_162105.982_+02:00.gif', '2023-06-10 16:23:00+02:00'),
+    (3, '2023-11-10 12:57:45.897+01:00', '2023-11-10 12:57:52.3+01:00', 200, 4020.25, 610, 0, 45,
+        'train_20231110_125745.897_+01:00.jpg', 'train_20231110_125745.897_+01:00.gif', NULL);
+
+INSERT INTO trains_blob_cleanups VALUES (1, '2023-07-01 00:00:00+02:00');
+
+INSERT INTO temperatures VALUES (1, '2023-06-10 16:00:00+02:00', 41.5);
@@ -47,6 +47,22 @@ func RecordBrightnessContrast(avg float64, avgDev float64) {
 	brightnessAvgDev.Observe(avgDev)
 }
 
+// RecordSourceReconnect counts attempts to re-open a live video source, by result (e.g. success, failure).
+func RecordSourceReconnect(result string) {
+	sourceReconnects.WithLabelValues(result).Inc()
+}
+
+// RecordSequenceSplit counts sequences which had to be split because the direction of movement changed.
+func RecordSequenceSplit(parts int) {
+	sequenceSplits.Inc()
+	sequenceSplitParts.Add(float64(parts))
+}
+
+// RecordOffsetSearch counts frame offset searches by the window searched (narrow around a prediction, or full).
+func RecordOffsetSearch(window string) {
+	offsetSearches.WithLabelValues(window).Inc()
+}
+
 var (
 	frameDispositions = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -80,4 +96,30 @@ var (
 			Buckets: prometheus.ExponentialBucketsRange(0.0005, 1.0, 20),
 		},
 	)
+	sourceReconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trainbot_source_reconnects_total",
+			Help: "Attempts to re-open a live video source after it failed.",
+		},
+		[]string{"result"},
+	)
+	sequenceSplits = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trainbot_sequence_splits_total",
+			Help: "Sequences split because the direction of movement changed, e.g. crossing trains.",
+		},
+	)
+	sequenceSplitParts = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trainbot_sequence_split_parts_total",
+			Help: "Total number of parts resulting from split sequences.",
+		},
+	)
+	offsetSearches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trainbot_offset_searches_total",
+			Help: "Frame offset searches, by window searched (narrow around the predicted offset, or full).",
+		},
+		[]string{"window"},
+	)
 )
@@ -3,6 +3,7 @@ package stitch
 import (
 	"image"
 	"math"
+	"slices"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -12,23 +13,63 @@ import (
 	"jo-m.ch/go/trainbot/pkg/pmatch"
 )
 
+// Thresholds for the score returned by pmatch.Instance, which is the similarity of Config.MatchMode
+// (cosine similarity for pmatch.ModeCos), for all matchers including pmatch.MatcherPhaseCorr.
+// They do not apply to the peak confidence of pmatch.SearchRGBAPhaseCorr(), which has a different range:
+// Good matches are typically in 0.7-1 there, i.e. partly below goodCosScoreMove.
 const (
 	goodCosScoreNoMove = 0.99
 	goodCosScoreMove   = 0.925
-	minFramePeriodS    = 0.01
-	dxLowPassFactor    = 0.95
-	minContrastAvg     = 0.005
-	minContrastAvgDev  = 0.01
+	// Relaxed, because leading frames often only partially show the train.
+	goodCosScorePreRoll = 0.85
+	minFramePeriodS     = 0.01
+	dxLowPassFactor     = 0.95
+	minContrastAvg      = 0.005
+	minContrastAvgDev   = 0.01
+	// Number of recent frames the velocity for predicting dx is estimated from.
+	predictFrames = 3
+	// Search radius around the predicted dx, as a fraction of it, and the minimum [px].
+	predictRadiusFraction = 0.2
+	predictMinRadiusPx    = 3
 )
 
 // Config is the configuration for a AutoStitcher.
-// All values must be > 0, except for MinSpeedKPH which might also be 0.
+// All values must be > 0, except for MinSpeedKPH, PreRollFrames, MaxDwellS and MaxDyPx which might also be 0.
 type Config struct {
 	PixelsPerM          float64
 	MinSpeedKPH         float64
 	MaxSpeedKPH         float64
 	MinLengthM          float64
 	MaxFrameCountPerSeq int
+	// How many frames before the start of a sequence are re-evaluated and possibly prepended to it.
+	PreRollFrames int
+	// How long a train might stand still before the sequence is ended [s].
+	// Note that no new train can be detected during that time after a train has left.
+	MaxDwellS float64
+	// Max vertical offset between two frames (e.g. camera shake) to detect and compensate for [px].
+	// Search cost grows linearly with it.
+	MaxDyPx int
+	// Patch matching implementation, see pmatch.NewMatcher(). Empty means pmatch.MatcherDefault.
+	Matcher string
+	// Similarity score used for patch matching. The zero value is pmatch.ModeCos.
+	MatchMode pmatch.Mode
+	// Discard sequences recorded in darkness which look like they were caused by lights (e.g. headlights)
+	// instead of a train.
+	NightMode bool
+	// Trains with a Quality.Score() below this are flagged: kept, but not published. 0 disables flagging.
+	MinQuality float64
+	// How overlapping frames are combined in the stitched image. The zero value is BlendNone.
+	Blend BlendMode
+	// Site/camera identifier, copied to Train.Site. Empty for a single site.
+	Site string
+	// Region of the camera picture the frames are cropped from. Only recorded with trains, not used for processing.
+	Rect image.Rectangle
+	// If set, enables streaming mode: only a central strip of each frame is kept, spooled to a temporary file
+	// in this directory, so that sequences of any length can be recorded in bounded memory (MaxFrameCountPerSeq
+	// does not apply).
+	// The image is assembled from the strips (Blend is ignored) and scaled down if it gets too large,
+	// and the GIF is created from the image.
+	StreamDir string
 }
 
 func (c *Config) minPxPerFrame(framePeriodS float64) int {
@@ -74,16 +115,27 @@ type sequence struct {
 	// frame[i] contains the i-th frame.
 	// All frames must have the same image size.
 	frames []image.Image
-	// dx[x] is the pixel offset between frames[i-1] and frames[i].
+	// dx[x] is the pixel offset between frames[i-1] and frames[i], with sub-pixel precision.
 	// Speed of a frame, in pixels/s is calculated as dx[i]/(ts[i] - ts[i-1]).
 	// dx[0] must never be 0.
-	dx []int
+	dx []float64
+	// dy[i] is the vertical pixel offset between frames[i-1] and frames[i], e.g. due to camera shake.
+	dy []float64
 	// ts[i] is the timestamp of the i-th frame.
 	ts []time.Time
+	// stats[i] are the brightness statistics of the i-th frame.
+	stats []frameStats
+}
+
+// preRollFrame is a frame seen while no sequence was active.
+type preRollFrame struct {
+	color image.Image
+	rgba  *image.RGBA
+	ts    time.Time
 }
 
 // AutoStitcher is an automatic train detector and stitcher.
-// Use NewAutoStitcher() to create an instance.
+// Use NewAutoStitcher() to create an instance, and call Destroy() when done.
 type AutoStitcher struct {
 	c Config
 
@@ -95,52 +147,72 @@ type AutoStitcher struct {
 
 	seq          sequence
 	dxAbsLowPass float64
+	// Timestamp and sequence length at the last frame which has moved.
+	lastMoveTS  time.Time
+	lastMoveLen int
+
+	// Most recent frames (including the previous frame) while no sequence is active,
+	// at most PreRollFrames+1, oldest first.
+	preRoll []preRollFrame
+
+	// Spool for frame strips of the current sequence in streaming mode, created on demand.
+	spool *spool
 
 	pm pmatch.Instance
 }
 
 // NewAutoStitcher creates a new AutoStitcher.
+// Panics if c.Matcher or c.MatchMode is invalid.
 func NewAutoStitcher(c Config) *AutoStitcher {
+	pm, err := pmatch.NewMatcher(c.Matcher, c.MatchMode)
+	if err != nil {
+		log.Panic().Err(err).Msg("failed to create matcher")
+	}
+	log.Info().Str("kind", pm.Kind()).Stringer("mode", c.MatchMode).Msg("using matcher")
+
 	return &AutoStitcher{
 		c: c,
 
-		pm: pmatch.NewInstance(),
+		pm: pm,
 	}
 }
 
-func (r *AutoStitcher) findOffset(prev, curr *image.RGBA, maxDx int) (dx int, cos float64) {
+// Destroy frees all resources held by the stitcher.
+// Frames of the current sequence are discarded, call TryStitchAndReset() before to keep them.
+func (r *AutoStitcher) Destroy() {
+	r.reset()
+	r.pm.Destroy()
+}
+
+// findOffset returns the offset between prev and curr, with sub-pixel precision.
+// The full window of [-maxDx, maxDx] is searched.
+func (r *AutoStitcher) findOffset(prev, curr *image.RGBA, maxDx int) (dx, dy, cos float64) {
+	return r.findOffsetIn(prev, curr, maxDx, -maxDx, maxDx)
+}
+
+// findOffsetIn is like findOffset, but only searches horizontal offsets in [lo, hi],
+// which must be within [-maxDx, maxDx].
+func (r *AutoStitcher) findOffsetIn(prev, curr *image.RGBA, maxDx, lo, hi int) (dx, dy, cos float64) {
 	t0 := time.Now()
 	defer func() {
-		log.Trace().Dur("dur", time.Since(t0)).Msg("findOffset() duration")
+		log.Trace().Dur("dur", time.Since(t0)).Int("lo", lo).Int("hi", hi).Msg("findOffset() duration")
 	}()
 
 	if prev.Rect.Size() != curr.Rect.Size() {
 		log.Panic().Msg("inconsistent size, this should not happen")
 	}
 
-	// Centered crop from prev frame,
-	// width is 3x max pixels per frame given by max velocity
-	w := maxDx * 3
-	if prev.Rect.Dx() < w {
+	if prev.Rect.Dx() < maxDx*3 {
 		panic("frame width is too small")
 	}
-	// and height 1/2 of frame.
-	h := int(float64(prev.Rect.Dy())*1/2 + 1)
-	subRect := image.Rect(0, 0, w, h).
-		Add(curr.Rect.Min).
-		Add(
-			curr.Rect.Size().
-				Sub(image.Pt(int(w), h)).
-				Div(2),
-		)
-	sub, err := imutil.Sub(prev, subRect)
-	if err != nil {
-		log.Panic().Err(err).Msg("this should not happen")
+	if lo < -maxDx || hi > maxDx || lo > hi {
+		log.Panic().Int("lo", lo).Int("hi", hi).Int("maxDx", maxDx).Msg("invalid search range, this should not happen")
 	}
 
 	// Centered slice crop from next frame,
-	// width is 1x max pixels per frame given by max velocity and same height as above.
-	w = maxDx
+	// width is 1x max pixels per frame given by max velocity and height 1/2 of frame.
+	w := maxDx
+	h := int(float64(prev.Rect.Dy())*1/2 + 1)
 	sliceRect := image.Rect(0, 0, w, h).
 		Add(curr.Rect.Min).
 		Add(
@@ -154,11 +226,76 @@ func (r *AutoStitcher) findOffset(prev, curr *image.RGBA, maxDx int) (dx int, co
 		log.Panic().Err(err).Msg("this should not happen")
 	}
 
-	// We expect this x value to be found by the search if the frame has not moved.
-	xZero := sliceRect.Min.Sub(subRect.Min).X
+	// Crop from prev frame, covering the slice shifted by [lo, hi] horizontally
+	// (for the full range, this is 3x max pixels per frame, centered),
+	// and by the max vertical offset on both sides.
+	maxDy := max(0, min(r.c.MaxDyPx, (prev.Rect.Dy()-h)/2))
+	subRect := image.Rect(lo, -maxDy, w+hi, h+maxDy).Add(sliceRect.Min)
+	sub, err := imutil.Sub(prev, subRect)
+	if err != nil {
+		log.Panic().Err(err).Msg("this should not happen")
+	}
+
+	// We expect those values to be found by the search if the frame has not moved.
+	zero := sliceRect.Min.Sub(subRect.Min)
+
+	x, y, cos := r.pm.SearchRGBASubpixel(sub.(*image.RGBA), slice.(*image.RGBA))
+	return x - float64(zero.X), y - float64(zero.Y), cos
+}
+
+// predictDx predicts dx between the previous and the current frame from the velocity during the most recent frames
+// of the active sequence.
+// Returns false if there is no active sequence, or the recent movement was too slow or inconsistent for a prediction.
+func (r *AutoStitcher) predictDx(framePeriodS float64, minDx int) (float64, bool) {
+	n := len(r.seq.dx)
+	if n < predictFrames {
+		return 0, false
+	}
+
+	sign := isign(iround(r.seq.dx[n-1]))
+	var sumDx, sumDt float64
+	for i := n - predictFrames; i < n; i++ {
+		dxI := iround(r.seq.dx[i])
+		if iabs(dxI) < minDx || isign(dxI) != sign {
+			return 0, false
+		}
+
+		prevTS := *r.seq.startTS
+		if i > 0 {
+			prevTS = r.seq.ts[i-1]
+		}
+		sumDx += r.seq.dx[i]
+		sumDt += r.seq.ts[i].Sub(prevTS).Seconds()
+	}
+	if sumDt <= 0 {
+		return 0, false
+	}
+
+	return sumDx / sumDt * framePeriodS, true
+}
+
+// findOffsetPredicted is like findOffset, but first searches only a narrow window around the predicted dx.
+// If the match there is not good enough, or on the edge of the window, the full window is searched.
+func (r *AutoStitcher) findOffsetPredicted(prev, curr *image.RGBA, maxDx int, predDx float64) (dx, dy, cos float64) {
+	radius := max(predictMinRadiusPx, int(math.Ceil(math.Abs(predDx)*predictRadiusFraction)))
+	p := iround(predDx)
+	lo, hi := max(-maxDx, p-radius), min(maxDx, p+radius)
+	if lo > hi {
+		// Prediction is out of range.
+		prometheus.RecordOffsetSearch("full")
+		return r.findOffset(prev, curr, maxDx)
+	}
+
+	dx, dy, cos = r.findOffsetIn(prev, curr, maxDx, lo, hi)
+	dxI := iround(dx)
+	if cos >= goodCosScoreMove && dxI > lo && dxI < hi {
+		prometheus.RecordOffsetSearch("narrow")
+		return dx, dy, cos
+	}
 
-	x, _, cos := r.pm.SearchRGBA(sub.(*image.RGBA), slice.(*image.RGBA))
-	return x - xZero, cos
+	log.Debug().Float64("predDx", predDx).Float64("dx", dx).Float64("cos", cos).Msg("prediction failed, searching full window")
+	prometheus.RecordOffsetSearch("full")
+	return r.findOffset(prev, curr, maxDx)
 }
 
 func (r *AutoStitcher) reset() {
@@ -167,17 +304,114 @@ func (r *AutoStitcher) reset() {
 	r.seq = sequence{}
 	prometheus.RecordSequenceLength(0)
 	r.dxAbsLowPass = 0
+	r.preRoll = nil
+	r.closeSpool()
 }
 
-func (r *AutoStitcher) record(prevTS time.Time, frame image.Image, dx int, ts time.Time) {
-	log.Trace().Time("prevTS", prevTS).Time("ts", ts).Int("dx", dx).Msg("record")
+// truncate drops all but the first n frames from the sequence.
+func (r *AutoStitcher) truncate(n int) {
+	if n >= len(r.seq.dx) {
+		return
+	}
+
+	r.seq.frames = r.seq.frames[:n]
+	r.seq.dx = r.seq.dx[:n]
+	r.seq.dy = r.seq.dy[:n]
+	r.seq.ts = r.seq.ts[:n]
+	r.seq.stats = r.seq.stats[:n]
+}
+
+func (r *AutoStitcher) pushPreRoll(frameColor image.Image, frameRGBA *image.RGBA, ts time.Time) {
+	if r.c.PreRollFrames <= 0 {
+		return
+	}
+
+	if len(r.preRoll) > r.c.PreRollFrames {
+		copy(r.preRoll, r.preRoll[1:])
+		r.preRoll = r.preRoll[:len(r.preRoll)-1]
+	}
+	r.preRoll = append(r.preRoll, preRollFrame{frameColor, frameRGBA, ts})
+}
+
+// backFill re-evaluates the pre-roll frames after a new sequence has been started,
+// and prepends them to the sequence as long as they show motion consistent with startDx.
+func (r *AutoStitcher) backFill(startDx float64) {
+	defer func() {
+		r.preRoll = nil
+	}()
+
+	if len(r.preRoll) < 2 {
+		return
+	}
+
+	var frames []image.Image
+	var dx, dy []float64
+	var ts []time.Time
+	var stats []frameStats
+
+	// Walk backwards, starting from the previous frame.
+	next := r.preRoll[len(r.preRoll)-1]
+	for j := len(r.preRoll) - 2; j >= 0; j-- {
+		prev := r.preRoll[j]
+
+		framePeriodS := next.ts.Sub(prev.ts).Seconds()
+		if framePeriodS < minFramePeriodS {
+			break
+		}
+		minDx := r.c.minPxPerFrame(framePeriodS)
+		maxDx := r.c.maxPxPerFrame(framePeriodS)
+		if prev.rgba.Rect.Dx() < maxDx*3 {
+			break
+		}
+
+		d, dyy, cos := r.findOffset(prev.rgba, next.rgba, maxDx)
+		dI := iround(d)
+		if cos < goodCosScorePreRoll || isign(dI) != isign(iround(startDx)) || iabs(dI) < minDx || iabs(dI) > maxDx {
+			log.Debug().Float64("dx", d).Float64("cos", cos).Msg("end of pre-roll")
+			break
+		}
+
+		frames = append(frames, r.keepFrame(next.color, d))
+		dx = append(dx, d)
+		dy = append(dy, dyy)
+		ts = append(ts, next.ts)
+		s := newFrameStats(next.rgba)
+		s.score = cos
+		stats = append(stats, s)
+		next = prev
+	}
+
+	if len(frames) == 0 {
+		return
+	}
+	log.Info().Int("n", len(frames)).Msg("prepending pre-roll frames to sequence")
+
+	slices.Reverse(frames)
+	slices.Reverse(dx)
+	slices.Reverse(dy)
+	slices.Reverse(ts)
+	slices.Reverse(stats)
+	startTS := next.ts
+	r.seq.startTS = &startTS
+	r.seq.frames = append(frames, r.seq.frames...)
+	r.seq.dx = append(dx, r.seq.dx...)
+	r.seq.dy = append(dy, r.seq.dy...)
+	r.seq.ts = append(ts, r.seq.ts...)
+	r.seq.stats = append(stats, r.seq.stats...)
+	prometheus.RecordSequenceLength(len(r.seq.frames))
+}
+
+func (r *AutoStitcher) record(prevTS time.Time, frame image.Image, dx, dy float64, ts time.Time, stats frameStats) {
+	log.Trace().Time("prevTS", prevTS).Time("ts", ts).Float64("dx", dx).Float64("dy", dy).Msg("record")
 	if r.seq.startTS == nil {
 		r.seq.startTS = &prevTS
 	}
 
-	r.seq.frames = append(r.seq.frames, frame)
+	r.seq.frames = append(r.seq.frames, r.keepFrame(frame, dx))
 	r.seq.dx = append(r.seq.dx, dx)
+	r.seq.dy = append(r.seq.dy, dy)
 	r.seq.ts = append(r.seq.ts, ts)
+	r.seq.stats = append(r.seq.stats, stats)
 	prometheus.RecordSequenceLength(len(r.seq.frames))
 }
 
@@ -188,8 +422,14 @@ func iabs(i int) int {
 	return i
 }
 
+func iround(x float64) int {
+	return int(math.Round(x))
+}
+
 // TryStitchAndReset tries to stitch any remaining frames and resets the sequence.
-func (r *AutoStitcher) TryStitchAndReset() *Train {
+// If the direction of movement changes within the sequence (e.g. crossing trains),
+// it is split, and multiple trains might be returned.
+func (r *AutoStitcher) TryStitchAndReset() []*Train {
 	defer r.reset()
 
 	if len(r.seq.dx) == 0 {
@@ -197,13 +437,23 @@ func (r *AutoStitcher) TryStitchAndReset() *Train {
 		return nil
 	}
 
-	log.Info().Msg("end of sequence, trying to stitch")
-	train, err := fitAndStitch(r.seq, r.c)
-	if err != nil {
-		log.Err(err).Time("startTs", r.seq.ts[0]).Msg("unable to fit and stitch sequence")
+	seqs := splitSequence(r.seq)
+	log.Info().Int("n", len(seqs)).Msg("end of sequence, trying to stitch")
+	if len(seqs) > 1 {
+		prometheus.RecordSequenceSplit(len(seqs))
 	}
 
-	return train
+	var trains []*Train
+	for _, seq := range seqs {
+		train, err := fitAndStitch(seq, r.c)
+		if err != nil {
+			log.Err(err).Time("startTs", seq.ts[0]).Msg("unable to fit and stitch sequence")
+			continue
+		}
+		trains = append(trains, train)
+	}
+
+	return trains
 }
 
 func sum3(v [3]float64) float64 {
@@ -212,7 +462,8 @@ func sum3(v [3]float64) float64 {
 
 // Frame adds a frame to the AutoStitcher.
 // Takes ownership of the image data buffer, so be sure to make a copy before passing it.
-func (r *AutoStitcher) Frame(frameColor image.Image, ts time.Time) *Train {
+// Might return multiple trains at once, see TryStitchAndReset().
+func (r *AutoStitcher) Frame(frameColor image.Image, ts time.Time) []*Train {
 	t0 := time.Now()
 	defer func() {
 		log.Trace().Dur("dur", time.Since(t0)).Msg("Frame() duration")
@@ -228,6 +479,10 @@ func (r *AutoStitcher) Frame(frameColor image.Image, ts time.Time) *Train {
 		r.prevFrameTS = ts
 		r.prevFrameColor = frameColor
 		r.prevFrameRGBA = frameRGBA
+
+		if len(r.seq.dx) == 0 {
+			r.pushPreRoll(frameColor, frameRGBA, ts)
+		}
 	}()
 
 	if r.prevFrameColor == nil {
@@ -253,48 +508,77 @@ func (r *AutoStitcher) Frame(frameColor image.Image, ts time.Time) *Train {
 
 	// Check for minimal contrast and brightness.
 	avg, avgDev := avg.RGBAC(frameRGBA)
-	prometheus.RecordBrightnessContrast(sum3(avg)/3, sum3(avgDev)/3)
-	if sum3(avgDev)/3 < minContrastAvgDev {
+	stats := frameStats{brightness: sum3(avg) / 3, contrast: sum3(avgDev) / 3}
+	prometheus.RecordBrightnessContrast(stats.brightness, stats.contrast)
+	if stats.contrast < minContrastAvgDev {
 		log.Trace().Interface("avgDev", avgDev).Interface("avg", avg).Msg("contrast too low, discarding")
 		prometheus.RecordFrameDisposition("low_contrast")
 		return nil
 	}
 
-	dx, cos := r.findOffset(r.prevFrameRGBA, frameRGBA, maxDx)
-	log.Debug().Uint64("prevFrameIx", r.prevFrameIx).Int("dx", dx).Float64("cos", cos).Msg("received frame")
+	var dx, dy, cos float64
+	if predDx, ok := r.predictDx(framePeriodS, minDx); ok {
+		dx, dy, cos = r.findOffsetPredicted(r.prevFrameRGBA, frameRGBA, maxDx, predDx)
+	} else {
+		dx, dy, cos = r.findOffset(r.prevFrameRGBA, frameRGBA, maxDx)
+	}
+	log.Debug().Uint64("prevFrameIx", r.prevFrameIx).Float64("dx", dx).Float64("dy", dy).Float64("cos", cos).Msg("received frame")
+	stats.score = cos
+	// Sub-pixel precision is only used for fitting, all decisions are made on whole pixels.
+	dxI := iround(dx)
 
 	isActive := len(r.seq.dx) > 0
 	if isActive {
-		r.dxAbsLowPass = r.dxAbsLowPass*(dxLowPassFactor) + math.Abs(float64(dx))*(1-dxLowPassFactor)
+		r.dxAbsLowPass = r.dxAbsLowPass*(dxLowPassFactor) + math.Abs(float64(dxI))*(1-dxLowPassFactor)
 
 		// Bail out before we use too much memory.
-		if len(r.seq.dx) > r.c.MaxFrameCountPerSeq {
+		if r.c.StreamDir == "" && len(r.seq.dx) > r.c.MaxFrameCountPerSeq {
 			log.Debug().Int("MaxFrameCountPerSeq", r.c.MaxFrameCountPerSeq).Msg("len(r.seq.dx) > MaxFrameCountPerSeq")
 			return r.TryStitchAndReset()
 		}
 
+		dwelling := r.dxAbsLowPass < float64(minDx)
+		if dwelling && r.c.MaxDwellS > 0 && ts.Sub(r.lastMoveTS).Seconds() > r.c.MaxDwellS {
+			log.Debug().Float64("MaxDwellS", r.c.MaxDwellS).Msg("train has not moved for longer than MaxDwellS")
+			// Drop frames recorded while waiting for the train to move again.
+			r.truncate(r.lastMoveLen)
+			return r.TryStitchAndReset()
+		}
+
 		// We have reached the end of a sequence.
-		if r.dxAbsLowPass < float64(minDx) {
+		if dwelling && r.c.MaxDwellS <= 0 {
 			log.Debug().Float64("dxAbsLowPass", r.dxAbsLowPass).Msg("r.dxAbsLowPass < float64(minDx)")
 			return r.TryStitchAndReset()
 		}
 
-		r.record(r.prevFrameTS, frameColor, dx, ts)
+		if dwelling && dxI == 0 && iround(dy) == 0 {
+			// Save memory, the frame does not contribute to the stitched image anyways.
+			frameColor = r.seq.frames[len(r.seq.frames)-1]
+		}
+
+		r.record(r.prevFrameTS, frameColor, dx, dy, ts, stats)
+		if iabs(dxI) >= minDx {
+			r.lastMoveTS = ts
+			r.lastMoveLen = len(r.seq.dx)
+		}
 		prometheus.RecordFrameDisposition("recorded")
 		return nil
 	}
 
-	if cos >= goodCosScoreNoMove && iabs(dx) < minDx {
+	if cos >= goodCosScoreNoMove && iabs(dxI) < minDx {
 		log.Debug().Msg("not moving")
 		prometheus.RecordFrameDisposition("not_moving")
 		return nil
 	}
 
-	if cos >= goodCosScoreMove && iabs(dx) >= minDx && iabs(dx) <= maxDx {
+	if cos >= goodCosScoreMove && iabs(dxI) >= minDx && iabs(dxI) <= maxDx {
 		log.Info().Msg("start of new sequence")
 		prometheus.RecordFrameDisposition("recorded_new_sequence")
-		r.record(r.prevFrameTS, frameColor, dx, ts)
-		r.dxAbsLowPass = math.Abs(float64(dx))
+		r.record(r.prevFrameTS, frameColor, dx, dy, ts, stats)
+		r.backFill(dx)
+		r.dxAbsLowPass = math.Abs(float64(dxI))
+		r.lastMoveTS = ts
+		r.lastMoveLen = len(r.seq.dx)
 		return nil
 	}
 
@@ -303,7 +587,7 @@ func (r *AutoStitcher) Frame(frameColor image.Image, ts time.Time) *Train {
 		Float64("goodCosScoreMove", goodCosScoreMove).
 		Interface("avgDev", avgDev).
 		Interface("avg", avg).
-		Int("dx", dx).
+		Float64("dx", dx).
 		Int("minDx", minDx).
 		Int("maxDx", maxDx).
 		Msg("inconclusive frame")
@@ -14,6 +14,7 @@ import (
 	"jo-m.ch/go/trainbot/internal/pkg/logging"
 	"jo-m.ch/go/trainbot/internal/pkg/testutil"
 	"jo-m.ch/go/trainbot/pkg/imutil"
+	"jo-m.ch/go/trainbot/pkg/pmatch"
 	"jo-m.ch/go/trainbot/pkg/vid"
 )
 
@@ -43,11 +44,12 @@ func runTestSimple(t *testing.T, c Config, r image.Rectangle, video string, leng
 	logFile, log.Logger = getFileLogger(t, video+".log")
 	defer logFile.Close()
 
-	src, err := vid.NewFileSrc(video, false)
+	src, err := vid.NewFileSrc(vid.FileConfig{Path: video})
 	require.NoError(t, err)
 	defer src.Close()
 
 	auto := NewAutoStitcher(c)
+	defer auto.Destroy()
 
 	var trains []Train
 	defer func() {
@@ -73,14 +75,13 @@ func runTestSimple(t *testing.T, c Config, r image.Rectangle, video string, leng
 
 		frame, err = imutil.Sub(frame, r)
 		require.NoError(t, err)
-		tr := auto.Frame(imutil.Copy(frame), *ts)
-		if tr != nil {
+		for _, tr := range auto.Frame(imutil.Copy(frame), *ts) {
 			trains = append(trains, *tr)
 			log.Info().Msg("got train")
 		}
 	}
 
-	if tr := auto.TryStitchAndReset(); tr != nil {
+	for _, tr := range auto.TryStitchAndReset() {
 		trains = append(trains, *tr)
 	}
 
@@ -156,3 +157,62 @@ func Test_AutoStitcher_Set0(t *testing.T) {
 	runTestDetailed(t, c, r, "testdata/set0/rain.mp4", "testdata/set0/rain.jpg", 82, 17.9, 0, true)
 	runTestDetailed(t, c, r, "testdata/set0/snow.mp4", "testdata/set0/snow.jpg", 56, 20.5, -0.75, true)
 }
+
+// Test_AutoStitcher_Set0_Matchers runs the alternative matchers on the same data, for comparison.
+func Test_AutoStitcher_Set0_Matchers(t *testing.T) {
+	for _, m := range []string{pmatch.MatcherPyramid, pmatch.MatcherPhaseCorr} {
+		t.Run(m, func(t *testing.T) {
+			c := Config{
+				PixelsPerM:          50,
+				MinSpeedKPH:         10,
+				MaxSpeedKPH:         160,
+				MinLengthM:          10,
+				MaxFrameCountPerSeq: 1500,
+				Matcher:             m,
+			}
+			r := image.Rect(0, 0, 300, 300)
+
+			runTestSimple(t, c, r, "testdata/set0/day.mp4", 86)
+			runTestSimple(t, c, r, "testdata/set0/night.mp4", 83)
+			runTestSimple(t, c, r, "testdata/set0/rain.mp4", 82)
+			runTestSimple(t, c, r, "testdata/set0/snow.mp4", 56)
+		})
+	}
+}
+
+// runTestBlend stitches a video with and without blending, dumps both images for visual comparison,
+// and checks that blending does not increase vertical banding.
+func runTestBlend(t *testing.T, c Config, r image.Rectangle, video string, lengthM float64) {
+	t.Helper()
+
+	var banding []float64
+	for _, b := range []BlendMode{BlendNone, BlendFeather} {
+		c.Blend = b
+		trains := runTestSimple(t, c, r, video, lengthM)
+		if len(trains) != 1 {
+			return
+		}
+
+		imutil.Dump(fmt.Sprintf("%s-blend-%s.jpg", video, b), trains[0].Image)
+		banding = append(banding, columnBanding(trains[0].Image))
+	}
+
+	t.Logf("%s: column banding %.3f (none) vs. %.3f (feather)", video, banding[0], banding[1])
+	assert.LessOrEqual(t, banding[1], banding[0], "blending increased banding: %s", video)
+}
+
+func Test_AutoStitcher_Set0_Blend(t *testing.T) {
+	c := Config{
+		PixelsPerM:          50,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         160,
+		MinLengthM:          10,
+		MaxFrameCountPerSeq: 1500,
+	}
+	r := image.Rect(0, 0, 300, 300)
+
+	runTestBlend(t, c, r, "testdata/set0/day.mp4", 86)
+	runTestBlend(t, c, r, "testdata/set0/night.mp4", 83)
+	runTestBlend(t, c, r, "testdata/set0/rain.mp4", 82)
+	runTestBlend(t, c, r, "testdata/set0/snow.mp4", 56)
+}
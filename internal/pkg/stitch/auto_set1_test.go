@@ -3,6 +3,7 @@
 package stitch
 
 import (
+	"fmt"
 	"image"
 	"testing"
 )
@@ -52,3 +53,18 @@ func Test_AutoStitcher_Set1(t *testing.T) {
 
 	runTestSimple(t, c, r, "testdata/set1/negative001.mkv", 0)
 }
+
+func Test_AutoStitcher_Set1_Blend(t *testing.T) {
+	c := Config{
+		PixelsPerM:          42,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         120,
+		MinLengthM:          10,
+		MaxFrameCountPerSeq: 1500,
+	}
+	r := image.Rect(0, 0, 206, 290)
+
+	for i := 1; i <= 32; i++ {
+		runTestBlend(t, c, r, fmt.Sprintf("testdata/set1/train%03d.mkv", i), anyLengthMagic)
+	}
+}
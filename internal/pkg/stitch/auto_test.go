@@ -0,0 +1,291 @@
+package stitch
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+	"jo-m.ch/go/trainbot/pkg/pmatch"
+)
+
+// slidingFrames returns n frames of size w x h, cut out of a random texture, where the content moves by -dx px per frame.
+// The first nStatic frames do not move.
+func slidingFrames(n, nStatic, w, h, dx int) []*image.RGBA {
+	tex := imutil.RandRGBA(123, w+n*dx, h)
+	var ret []*image.RGBA
+	x := 0
+	for i := 0; i < n; i++ {
+		if i >= nStatic {
+			x += dx
+		}
+		sub, err := imutil.Sub(tex, image.Rect(x, 0, x+w, h))
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, imutil.ToRGBA(sub))
+	}
+	return ret
+}
+
+func Test_AutoStitcher_backFill(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+		PreRollFrames:       5,
+	}
+	frames := slidingFrames(10, 3, 200, 40, 8)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(i int) time.Time {
+		return start.Add(time.Duration(i) * 100 * time.Millisecond)
+	}
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+	for i := 0; i < 9; i++ {
+		r.pushPreRoll(frames[i], frames[i], ts(i))
+	}
+	require.Len(t, r.preRoll, 6)
+
+	// New sequence started on frame 9.
+	dx, dy, cos := r.findOffset(frames[8], frames[9], c.maxPxPerFrame(0.1))
+	require.Greater(t, cos, goodCosScoreMove)
+	r.record(ts(8), frames[9], dx, dy, ts(9), frameStats{})
+	r.backFill(dx)
+
+	// The pre-roll buffer holds frames 3-8, all moving, so frames 4-8 are prepended and frame 3 becomes frames[-1].
+	assert.InDeltaSlice(t, []float64{8, 8, 8, 8, 8, 8}, r.seq.dx, 0.1)
+	assert.Equal(t, ts(3), *r.seq.startTS)
+	assert.Equal(t, []time.Time{ts(4), ts(5), ts(6), ts(7), ts(8), ts(9)}, r.seq.ts)
+	assert.Equal(t, image.Image(frames[4]), r.seq.frames[0])
+	assert.Nil(t, r.preRoll)
+}
+
+func Test_AutoStitcher_backFill_Static(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+		PreRollFrames:       5,
+	}
+	// Frames 0-5 are static.
+	frames := slidingFrames(10, 6, 200, 40, 8)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(i int) time.Time {
+		return start.Add(time.Duration(i) * 100 * time.Millisecond)
+	}
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+	for i := 0; i < 9; i++ {
+		r.pushPreRoll(frames[i], frames[i], ts(i))
+	}
+
+	dx, dy, _ := r.findOffset(frames[8], frames[9], c.maxPxPerFrame(0.1))
+	r.record(ts(8), frames[9], dx, dy, ts(9), frameStats{})
+	r.backFill(dx)
+
+	// Back-filling stops at the static frames.
+	assert.InDeltaSlice(t, []float64{8, 8, 8, 8}, r.seq.dx, 0.1)
+	assert.Equal(t, ts(5), *r.seq.startTS)
+}
+
+func Test_AutoStitcher_findOffset_dy(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+		MaxDyPx:             4,
+	}
+	tex := imutil.RandRGBA(123, 300, 60)
+	frame := func(x, y int) *image.RGBA {
+		sub, err := imutil.Sub(tex, image.Rect(x, y, x+200, y+40))
+		require.NoError(t, err)
+		return imutil.ToRGBA(sub)
+	}
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+
+	for _, tc := range []struct{ dx, dy int }{{8, 0}, {8, 3}, {-5, -2}, {0, 4}} {
+		dx, dy, cos := r.findOffset(frame(50, 10), frame(50+tc.dx, 10+tc.dy), c.maxPxPerFrame(0.1))
+		assert.InDelta(t, tc.dx, dx, 0.1)
+		assert.InDelta(t, tc.dy, dy, 0.1)
+		assert.InDelta(t, 1, cos, 1e-6)
+	}
+
+	// Vertical search disabled.
+	r.c.MaxDyPx = 0
+	dx, dy, _ := r.findOffset(frame(50, 10), frame(58, 10), c.maxPxPerFrame(0.1))
+	assert.InDelta(t, 8, dx, 0.1)
+	assert.Equal(t, 0., dy)
+}
+
+func Test_AutoStitcher_findOffset_Matchers(t *testing.T) {
+	frames := slidingFrames(3, 2, 200, 40, 8)
+	for _, m := range []string{pmatch.MatcherDefault, pmatch.MatcherPyramid, pmatch.MatcherPhaseCorr} {
+		c := Config{
+			PixelsPerM:          10,
+			MinSpeedKPH:         10,
+			MaxSpeedKPH:         100,
+			MinLengthM:          1,
+			MaxFrameCountPerSeq: 100,
+			Matcher:             m,
+		}
+		r := NewAutoStitcher(c)
+
+		dx, dy, cos := r.findOffset(frames[1], frames[2], c.maxPxPerFrame(0.1))
+		assert.InDelta(t, 8, dx, 0.1, m)
+		assert.Equal(t, 0., dy, m)
+		assert.InDelta(t, 1, cos, 1e-6, m)
+
+		dx, _, cos = r.findOffset(frames[0], frames[1], c.maxPxPerFrame(0.1))
+		assert.InDelta(t, 0, dx, 0.1, m)
+		assert.InDelta(t, 1, cos, 1e-6, m)
+		r.Destroy()
+	}
+
+	assert.Panics(t, func() {
+		NewAutoStitcher(Config{Matcher: "unknown"})
+	})
+}
+
+func Test_AutoStitcher_findOffset_ZNCC(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+		MatchMode:           pmatch.ModeZNCC,
+	}
+	frames := slidingFrames(3, 2, 200, 40, 8)
+	// Simulate an exposure change.
+	brighter := imutil.ToRGBA(frames[2])
+	for i := range brighter.Pix {
+		if i%4 != 3 {
+			brighter.Pix[i] = uint8(min(255, int(brighter.Pix[i])+15))
+		}
+	}
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+
+	dx, dy, score := r.findOffset(frames[1], brighter, c.maxPxPerFrame(0.1))
+	assert.InDelta(t, 8, dx, 0.1)
+	assert.Equal(t, 0., dy)
+	assert.Greater(t, score, goodCosScoreMove)
+}
+
+func Test_AutoStitcher_findOffsetPredicted(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+	}
+	frames := slidingFrames(3, 1, 200, 40, 8)
+	maxDx := c.maxPxPerFrame(0.1)
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+
+	dxFull, dyFull, cosFull := r.findOffset(frames[1], frames[2], maxDx)
+
+	// Narrow window gives the same result.
+	dx, dy, cos := r.findOffsetIn(frames[1], frames[2], maxDx, 5, 11)
+	assert.InDelta(t, dxFull, dx, 1e-9)
+	assert.InDelta(t, dyFull, dy, 1e-9)
+	assert.InDelta(t, cosFull, cos, 1e-9)
+
+	dx, _, cos = r.findOffsetPredicted(frames[1], frames[2], maxDx, 7.5)
+	assert.InDelta(t, dxFull, dx, 1e-9)
+	assert.InDelta(t, cosFull, cos, 1e-9)
+
+	// Wrong prediction, falls back to the full window.
+	dx, _, cos = r.findOffsetPredicted(frames[1], frames[2], maxDx, -8)
+	assert.InDelta(t, dxFull, dx, 1e-9)
+	assert.InDelta(t, cosFull, cos, 1e-9)
+}
+
+func Test_AutoStitcher_predictDx(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(i int) time.Time {
+		return start.Add(time.Duration(i) * 100 * time.Millisecond)
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+
+	_, ok := r.predictDx(0.1, 2)
+	assert.False(t, ok)
+
+	for i, dx := range []float64{-10, -9, -10, -11} {
+		r.record(ts(i), frame, dx, 0, ts(i+1), frameStats{})
+	}
+	pred, ok := r.predictDx(0.1, 2)
+	require.True(t, ok)
+	assert.InDelta(t, -10, pred, 1e-9)
+
+	// Scales with the frame period.
+	pred, ok = r.predictDx(0.2, 2)
+	require.True(t, ok)
+	assert.InDelta(t, -20, pred, 1e-9)
+
+	// Slowing down too much.
+	r.record(ts(4), frame, -1, 0, ts(5), frameStats{})
+	_, ok = r.predictDx(0.1, 2)
+	assert.False(t, ok)
+}
+
+func Test_AutoStitcher_TrainTiming(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+		Site:                "test-site",
+		Rect:                image.Rect(10, 20, 210, 60),
+	}
+	frames := slidingFrames(20, 3, 200, 40, 8)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(i int) time.Time {
+		return start.Add(time.Duration(i) * 100 * time.Millisecond)
+	}
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+	for i, f := range frames {
+		require.Empty(t, r.Frame(f, ts(i)))
+	}
+	trains := r.TryStitchAndReset()
+	require.Len(t, trains, 1)
+
+	// Moving from frame 3 to the last frame.
+	train := trains[0]
+	assert.Equal(t, ts(3), train.StartTS)
+	assert.Equal(t, ts(19), train.EndTS)
+	assert.InDelta(t, 1.6, train.DurationS(), 1e-9)
+	assert.InDelta(t, 10, train.FPS, 1e-9)
+	assert.Equal(t, c.Rect, train.Conf.Rect)
+	assert.Equal(t, "test-site", train.Site)
+}
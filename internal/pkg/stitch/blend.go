@@ -0,0 +1,137 @@
+package stitch
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// BlendMode selects how overlapping frames are combined by stitch().
+type BlendMode int
+
+const (
+	// BlendNone draws each full frame on top of the previous one. This is the default.
+	BlendNone BlendMode = iota
+	// BlendFeather only uses a central strip of each frame, and linearly feathers the seams between strips.
+	// Hides exposure differences and slight misalignments between frames, but needs more memory.
+	BlendFeather
+)
+
+const (
+	// Width of the linear transition between the strips of two frames, on each side of the seam [px].
+	blendFeatherPx = 8
+	// Weight of frame pixels outside of strip and feathering.
+	// Only matters where no strip covers the image, i.e. at the start and end of a train.
+	blendMinWeight = 1e-6
+)
+
+var blendModeNames = map[BlendMode]string{
+	BlendNone:    "none",
+	BlendFeather: "feather",
+}
+
+// String implements fmt.Stringer.
+func (m BlendMode) String() string {
+	name, ok := blendModeNames[m]
+	if !ok {
+		return fmt.Sprintf("BlendMode(%d)", int(m))
+	}
+	return name
+}
+
+// ParseBlendMode parses a blend mode from its name (as returned by String()).
+func ParseBlendMode(name string) (BlendMode, error) {
+	for m, n := range blendModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown blend mode '%s'", name)
+}
+
+// featherWeight computes the weight of column u of a frame, given the strip [l, r) in frame coordinates.
+func featherWeight(u, l, r float64) float64 {
+	d := 0.
+	if u < l {
+		d = l - u
+	} else if u > r {
+		d = u - r
+	}
+	return max(blendMinWeight, 1-d/blendFeatherPx)
+}
+
+// blendFeather draws frames onto img, see BlendFeather.
+// pos[i] is the horizontal position of frame i on img, dx[i] the offset between frames i and i+1,
+// offY the vertical position of each frame, and sign the direction of the sequence (see dxSign()).
+func blendFeather(img *image.RGBA, frames []image.Image, dx, pos, offY []int, sign int) {
+	fb := frames[0].Bounds()
+	fw, fh := fb.Dx(), fb.Dy()
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	acc := make([]float32, w*h*3)
+	wSum := make([]float64, w)
+	buf := image.NewRGBA(image.Rectangle{Max: fb.Size()})
+	weights := make([]float64, fw)
+	cx := float64(fw) / 2
+
+	for i, f := range frames {
+		draw.Draw(buf, buf.Bounds(), f, fb.Min, draw.Src)
+
+		// Seams to the previous and next frames are halfway between the frame centers.
+		// The first and last frames cover everything on their outer side.
+		bPrev, bNext := math.Inf(-sign), math.Inf(sign)
+		if i > 0 {
+			bPrev = cx - float64(dx[i-1])/2
+		}
+		if i < len(frames)-1 {
+			bNext = cx + float64(dx[i])/2
+		}
+		l, r := min(bPrev, bNext), max(bPrev, bNext)
+		for u := range weights {
+			weights[u] = featherWeight(float64(u)+0.5, l, r)
+		}
+
+		for u := range fw {
+			x := pos[i] + u
+			if x < 0 || x >= w {
+				continue
+			}
+			wSum[x] += weights[u]
+		}
+
+		for v := range fh {
+			y := v + offY[i]
+			if y < 0 || y >= h {
+				continue
+			}
+			row := buf.Pix[v*buf.Stride:]
+			accRow := acc[y*w*3:]
+			for u := range fw {
+				x := pos[i] + u
+				if x < 0 || x >= w {
+					continue
+				}
+				wt := float32(weights[u])
+				accRow[x*3] += wt * float32(row[u*4])
+				accRow[x*3+1] += wt * float32(row[u*4+1])
+				accRow[x*3+2] += wt * float32(row[u*4+2])
+			}
+		}
+	}
+
+	for y := range h {
+		row := img.Pix[y*img.Stride:]
+		accRow := acc[y*w*3:]
+		for x := range w {
+			if wSum[x] == 0 {
+				continue
+			}
+			norm := float32(1 / wSum[x])
+			for c := range 3 {
+				row[x*4+c] = uint8(min(255, max(0, math.Round(float64(accRow[x*3+c]*norm)))))
+			}
+			row[x*4+3] = 0xff
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+// columnBanding measures visible vertical bands in img: RMS difference of the brightness of adjacent columns.
+// Hard steps are penalized more than smooth transitions.
+func columnBanding(img *image.RGBA) float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	cols := make([]float64, w)
+	for y := range h {
+		row := img.Pix[y*img.Stride:]
+		for x := range w {
+			cols[x] += float64(row[x*4]) + float64(row[x*4+1]) + float64(row[x*4+2])
+		}
+	}
+
+	var sum float64
+	for x := 1; x < w; x++ {
+		d := (cols[x] - cols[x-1]) / float64(h*3)
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(w-1))
+}
+
+// blendTestFrames cuts frames out of a random (or flat gray) texture, moving by dx px per frame,
+// with the brightness of each frame offset by exposure[i].
+func blendTestFrames(dx int, exposure []int, flat bool) ([]image.Image, []int, []int) {
+	n := len(exposure)
+	tex := imutil.RandRGBA(123, 100+n*iabs(dx), 40)
+	if flat {
+		draw.Draw(tex, tex.Bounds(), image.NewUniform(color.Gray{100}), image.Point{}, draw.Src)
+	}
+	var frames []image.Image
+	dxs := make([]int, n)
+	y := make([]int, n)
+	for i := range n {
+		x := i * dx
+		if dx < 0 {
+			x = (n - 1 - i) * -dx
+		}
+		sub, err := imutil.Sub(tex, image.Rect(x, 0, x+100, 40))
+		if err != nil {
+			panic(err)
+		}
+		f := imutil.ToRGBA(sub)
+		for j := range f.Pix {
+			if j%4 != 3 {
+				f.Pix[j] = uint8(min(255, max(0, int(f.Pix[j])+exposure[i])))
+			}
+		}
+		frames = append(frames, f)
+		dxs[i] = dx
+	}
+	return frames, dxs, y
+}
+
+func Test_ParseBlendMode(t *testing.T) {
+	for _, m := range []BlendMode{BlendNone, BlendFeather} {
+		parsed, err := ParseBlendMode(m.String())
+		require.NoError(t, err)
+		assert.Equal(t, m, parsed)
+	}
+
+	_, err := ParseBlendMode("unknown")
+	assert.Error(t, err)
+}
+
+func Test_featherWeight(t *testing.T) {
+	assert.Equal(t, 1., featherWeight(10, 5, 15))
+	assert.Equal(t, 0.5, featherWeight(1, 5, 15))
+	assert.Equal(t, 0.5, featherWeight(19, 5, 15))
+	assert.Equal(t, blendMinWeight, featherWeight(50, 5, 15))
+	assert.Equal(t, 1., featherWeight(-1000, math.Inf(-1), 15))
+}
+
+func Test_stitch_BlendFeather(t *testing.T) {
+	for _, dx := range []int{20, -20} {
+		// Without exposure differences, the result is the same as without blending.
+		frames, dxs, y := blendTestFrames(dx, make([]int, 6), false)
+
+		hard, err := stitch(frames, dxs, y, BlendNone)
+		require.NoError(t, err)
+		soft, err := stitch(frames, dxs, y, BlendFeather)
+		require.NoError(t, err)
+		require.Equal(t, hard.Bounds(), soft.Bounds())
+		for i := range hard.Pix {
+			if i%4 == 3 {
+				// The test texture has random alpha, blending always produces opaque pixels.
+				continue
+			}
+			require.InDelta(t, hard.Pix[i], soft.Pix[i], 1, "dx=%d i=%d", dx, i)
+		}
+
+		// With exposure differences, blending reduces banding.
+		frames, dxs, y = blendTestFrames(dx, []int{0, 30, -10, 25, 0, -20}, true)
+		hard, err = stitch(frames, dxs, y, BlendNone)
+		require.NoError(t, err)
+		soft, err = stitch(frames, dxs, y, BlendFeather)
+		require.NoError(t, err)
+		assert.Less(t, columnBanding(soft), columnBanding(hard)/2, "dx=%d", dx)
+	}
+
+	// Standing still at the start.
+	for _, dx := range []int{20, -20} {
+		frames, dxs, y := blendTestFrames(dx, make([]int, 6), false)
+		frames = append([]image.Image{frames[0]}, frames...)
+		dxs = append([]int{0}, dxs...)
+		y = append([]int{0}, y...)
+
+		hard, err := stitch(frames, dxs, y, BlendNone)
+		require.NoError(t, err)
+		soft, err := stitch(frames, dxs, y, BlendFeather)
+		require.NoError(t, err)
+		require.Equal(t, hard.Bounds(), soft.Bounds())
+		for i := range hard.Pix {
+			if i%4 != 3 {
+				require.InDelta(t, hard.Pix[i], soft.Pix[i], 1, "dx=%d i=%d", dx, i)
+			}
+		}
+	}
+
+	frames, dxs, y := blendTestFrames(20, make([]int, 2), false)
+	_, err := stitch(frames, dxs, y, BlendMode(99))
+	assert.Error(t, err)
+}
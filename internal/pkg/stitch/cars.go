@@ -0,0 +1,152 @@
+package stitch
+
+import (
+	"image"
+	"math"
+	"slices"
+)
+
+const (
+	// Band of the image rows in which gaps between cars are searched, as fraction of the image height.
+	// Couplers and the gaps above them are closer to the ground than to the roof.
+	carBandTop    = 0.5
+	carBandBottom = 0.9
+	// Width of the smoothing window of the column profile [m].
+	carSmoothM = 0.2
+	// Width of the window of the running median which is used as car body baseline [m].
+	carBaselineM = 6
+	// Min. and max. width of a gap between two cars [m].
+	carGapMinM = 0.3
+	carGapMaxM = 3
+	// Shorter cars are merged with their neighbors [m].
+	carMinLengthM = 6
+	// Min. difference of the column brightness to the baseline to be part of a gap, and as multiple of its robust std.
+	carGapMinDiff = 0.08
+	carGapSigmas  = 3
+)
+
+// Car is a single vehicle of a train, segmented from the stitched image.
+type Car struct {
+	// Position of the left edge of the car in Train.Image, from the left edge of the image [m].
+	StartM float64
+	// Always positive.
+	LengthM float64
+}
+
+// columnProfile returns the mean brightness of each column of img within rows [y0, y1), [0, 1].
+func columnProfile(img *image.RGBA, y0, y1 int) []float64 {
+	w := img.Rect.Dx()
+	ret := make([]float64, w)
+	for y := y0; y < y1; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := range w {
+			ret[x] += float64(row[x*4]) + float64(row[x*4+1]) + float64(row[x*4+2])
+		}
+	}
+	for x := range ret {
+		ret[x] /= float64((y1-y0)*3) * 0xff
+	}
+	return ret
+}
+
+// boxFilter returns the mean of v over [i-r, i+r] for each i, clipped to the bounds of v.
+func boxFilter(v []float64, r int) []float64 {
+	cum := make([]float64, len(v)+1)
+	for i, x := range v {
+		cum[i+1] = cum[i] + x
+	}
+	ret := make([]float64, len(v))
+	for i := range v {
+		lo, hi := max(0, i-r), min(len(v), i+r+1)
+		ret[i] = (cum[hi] - cum[lo]) / float64(hi-lo)
+	}
+	return ret
+}
+
+// runningMedian returns the median of v over [i-r, i+r] for each i, clipped to the bounds of v.
+// To save time, it is only evaluated every stride elements, and held in between.
+func runningMedian(v []float64, r, stride int) []float64 {
+	ret := make([]float64, len(v))
+	buf := make([]float64, 0, 2*r+1)
+	for i := 0; i < len(v); i += stride {
+		buf = append(buf[:0], v[max(0, i-r):min(len(v), i+r+1)]...)
+		slices.Sort(buf)
+		m := buf[len(buf)/2]
+		for j := i; j < min(len(v), i+stride); j++ {
+			ret[j] = m
+		}
+	}
+	return ret
+}
+
+// findGaps returns the centers of all runs of elements in dev which exceed thresh,
+// and are between minW and maxW elements wide.
+func findGaps(dev []float64, thresh float64, minW, maxW int) []int {
+	var ret []int
+	start := -1
+	for i := 0; i <= len(dev); i++ {
+		in := i < len(dev) && dev[i] > thresh
+		if in && start < 0 {
+			start = i
+		}
+		if !in && start >= 0 {
+			if w := i - start; w >= minW && w <= maxW {
+				ret = append(ret, start+w/2)
+			}
+			start = -1
+		}
+	}
+	return ret
+}
+
+// segmentCars splits a stitched train image into cars by looking for gaps between them,
+// i.e. columns in the lower part of the image which differ from the car bodies around them.
+// pxPerM is the scale of the image. Returns at least one car, unless the image is empty.
+func segmentCars(img *image.RGBA, pxPerM float64) []Car {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	y0, y1 := int(float64(h)*carBandTop), int(float64(h)*carBandBottom)
+	if w == 0 || y1 <= y0 || pxPerM <= 0 {
+		return nil
+	}
+
+	profile := boxFilter(columnProfile(img, y0, y1), int(carSmoothM*pxPerM/2))
+	baselineR := max(1, int(carBaselineM*pxPerM/2))
+	baseline := runningMedian(profile, baselineR, max(1, baselineR/8))
+
+	dev := make([]float64, w)
+	for x := range dev {
+		dev[x] = math.Abs(profile[x] - baseline[x])
+	}
+	// Robust std estimate from the median absolute deviation.
+	sorted := slices.Clone(dev)
+	slices.Sort(sorted)
+	sigma := sorted[len(sorted)/2] * 1.4826
+	thresh := max(carGapMinDiff, sigma*carGapSigmas)
+
+	gaps := findGaps(dev, thresh, max(1, int(carGapMinM*pxPerM)), int(carGapMaxM*pxPerM))
+
+	// Drop boundaries which would result in too short cars.
+	minLen := int(carMinLengthM * pxPerM)
+	var bounds []int
+	last := 0
+	for _, g := range gaps {
+		if g-last >= minLen {
+			bounds = append(bounds, g)
+			last = g
+		}
+	}
+	if len(bounds) > 0 && w-bounds[len(bounds)-1] < minLen {
+		bounds = bounds[:len(bounds)-1]
+	}
+
+	cars := make([]Car, 0, len(bounds)+1)
+	start := 0
+	for _, b := range append(bounds, w) {
+		cars = append(cars, Car{
+			StartM:  float64(start) / pxPerM,
+			LengthM: float64(b-start) / pxPerM,
+		})
+		start = b
+	}
+	return cars
+}
@@ -0,0 +1,85 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+// carsTestImage draws a train with cars of the given lengths [px], separated by gaps of gapPx,
+// in front of a bright background. Each car has its own (dark) color and some texture.
+func carsTestImage(lengths []int, gapPx, h int) *image.RGBA {
+	w := 0
+	for _, l := range lengths {
+		w += l + gapPx
+	}
+	w -= gapPx
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(color.Gray{220}), image.Point{}, draw.Src)
+	tex := imutil.RandRGBA(1, w, h)
+
+	x := 0
+	for i, l := range lengths {
+		car := image.Rect(x, 0, x+l, h)
+		draw.Draw(img, car, image.NewUniform(color.RGBA{uint8(40 + 30*i), 60, 80, 0xff}), image.Point{}, draw.Src)
+		// Texture, but not too much.
+		for y := range h {
+			for xx := car.Min.X; xx < car.Max.X; xx++ {
+				o := y*img.Stride + xx*4
+				for c := range 3 {
+					img.Pix[o+c] += tex.Pix[o+c] / 8
+				}
+			}
+		}
+		x += l + gapPx
+	}
+	return img
+}
+
+func Test_findGaps(t *testing.T) {
+	dev := []float64{0, 1, 1, 0, 1, 1, 1, 1, 0, 1}
+	assert.Equal(t, []int{2}, findGaps(dev, 0.5, 2, 3))
+	assert.Equal(t, []int{2, 6, 9}, findGaps(dev, 0.5, 1, 4))
+	assert.Empty(t, findGaps(dev, 1, 1, 4))
+}
+
+func Test_runningMedian(t *testing.T) {
+	v := []float64{1, 1, 9, 1, 1, 5, 5, 5, 5}
+	assert.Equal(t, []float64{1, 1, 1, 1, 5, 5, 5, 5, 5}, runningMedian(v, 2, 1))
+	assert.Equal(t, []float64{1, 1, 1, 1, 1, 1, 5, 5, 5}, runningMedian(v, 2, 3))
+}
+
+func Test_segmentCars(t *testing.T) {
+	const pxPerM = 10
+	lengths := []int{180, 150, 150, 220, 80}
+	img := carsTestImage(lengths, 10, 60)
+
+	cars := segmentCars(img, pxPerM)
+	require.Len(t, cars, len(lengths))
+	start := 0.
+	for i, l := range lengths {
+		assert.InDelta(t, start, cars[i].StartM, 1, "car %d", i)
+		// Inner cars include half of the gap on each side.
+		assert.InDelta(t, float64(l)/pxPerM, cars[i].LengthM, 1, "car %d", i)
+		start += cars[i].LengthM
+	}
+	assert.InDelta(t, float64(img.Rect.Dx())/pxPerM, start, 1e-9)
+
+	// Cars shorter than carMinLengthM are merged.
+	cars = segmentCars(carsTestImage([]int{150, 30, 150}, 10, 60), pxPerM)
+	require.Len(t, cars, 2)
+	assert.InDelta(t, 15.5, cars[0].LengthM, 0.5)
+
+	// No gaps.
+	cars = segmentCars(carsTestImage([]int{500}, 0, 60), pxPerM)
+	assert.Equal(t, []Car{{0, 50}}, cars)
+
+	// Empty image.
+	assert.Nil(t, segmentCars(image.NewRGBA(image.Rectangle{}), pxPerM))
+}
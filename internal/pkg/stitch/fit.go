@@ -18,6 +18,43 @@ func model(t float64, params []float64) float64 {
 	return v0 + a*t
 }
 
+// prepareFit computes, for each data point of a sequence:
+// Time since last data point [s], time since start [s], current velocity [px/s].
+func prepareFit(seq sequence) (dt, t, v []float64) {
+	n := len(seq.dx)
+	dt = make([]float64, n)
+	t = make([]float64, n)
+	v = make([]float64, n)
+	for i := range seq.dx {
+		if i == 0 {
+			dt[i] = seq.ts[i].Sub(*seq.startTS).Seconds()
+		} else {
+			dt[i] = seq.ts[i].Sub(seq.ts[i-1]).Seconds()
+		}
+		t[i] = seq.ts[i].Sub(*seq.startTS).Seconds()
+		v[i] = seq.dx[i] / dt[i]
+	}
+	return
+}
+
+// roundDx rounds fitted dx values to integers, while making sure rounding errors do not accumulate.
+func roundDx(dxF []float64) []int {
+	ret := make([]int, len(dxF))
+	var roundErr float64 // Sum of values we have rounded away.
+	for i, x := range dxF {
+		dxRound := math.Round(x)
+		roundErr += x - dxRound
+
+		if math.Abs(roundErr) >= 0.5 {
+			dxRound += roundErr
+			roundErr -= sign(roundErr)
+		}
+
+		ret[i] = int(dxRound)
+	}
+	return ret
+}
+
 // Returns fitted dx values. Length will always be the same as the input.
 // Does not modify seq.
 // Also returns estimated length [px], v0 [px/s] and acceleration [px/s^2].
@@ -34,18 +71,7 @@ func fitDx(seq sequence, maxSpeedPxS float64) ([]int, float64, float64, float64,
 
 	// Prepare data for fitting.
 	n := len(seq.dx)
-	dt := make([]float64, n) // Time since last data point [s].
-	t := make([]float64, n)  // Time since start [s].
-	v := make([]float64, n)  // Current velocity [px/s].
-	for i := range seq.dx {
-		if i == 0 {
-			dt[i] = seq.ts[i].Sub(*seq.startTS).Seconds()
-		} else {
-			dt[i] = seq.ts[i].Sub(seq.ts[i-1]).Seconds()
-		}
-		t[i] = seq.ts[i].Sub(*seq.startTS).Seconds()
-		v[i] = float64(seq.dx[i]) / dt[i]
-	}
+	dt, t, v := prepareFit(seq)
 
 	// Fit.
 	params := ransac.MetaParams{
@@ -55,29 +81,20 @@ func fitDx(seq sequence, maxSpeedPxS float64) ([]int, float64, float64, float64,
 		InlierThreshold: maxSpeedPxS * 0.05, // 5% of max speed.
 		Seed:            0,
 	}
-	log.Debug().Floats64("t", t).Floats64("v", v).Ints("dx", seq.dx).Interface("params", params).Msg("RANSAC")
+	log.Debug().Floats64("t", t).Floats64("v", v).Floats64("dx", seq.dx).Interface("params", params).Msg("RANSAC")
 	fit, err := ransac.Ransac(t, v, model, modelNParams, params)
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
 
 	// Generate dx from fit.
-	dxFit := make([]int, n)
-	var roundErr float64 // Sum of values we have rounded away.
+	dxF := make([]float64, n)
 	for i := range seq.dx {
-		dxF := model(t[i], fit.X) * dt[i]
-		dxRound := math.Round(dxF)
-		roundErr += dxF - dxRound
-
-		if math.Abs(roundErr) >= 0.5 {
-			dxRound += roundErr
-			roundErr -= sign(roundErr)
-		}
-
-		dxFit[i] = int(dxRound)
+		dxF[i] = model(t[i], fit.X) * dt[i]
 	}
+	dxFit := roundDx(dxF)
 
-	log.Debug().Floats64("fit", fit.X).Ints("dxFit", dxFit).Float64("roundErr", roundErr).Msg("RANSAC results")
+	log.Debug().Floats64("fit", fit.X).Ints("dxFit", dxFit).Msg("RANSAC results")
 
 	v0 := fit.X[0]
 	a := fit.X[1]
@@ -87,3 +104,134 @@ func fitDx(seq sequence, maxSpeedPxS float64) ([]int, float64, float64, float64,
 	}
 	return dxFit, ds, v0, a, nil
 }
+
+// motionFit is the result of fitMotion().
+type motionFit struct {
+	// Fitted dx values, same length as the sequence.
+	dx []int
+	// Estimated length [px], always positive.
+	ds float64
+	// Representative speed [px/s] and acceleration [px/s^2].
+	// For a single segment, speed at halftime.
+	// For multiple segments, mean speed while moving, and acceleration of the longest moving segment.
+	speed, accel float64
+	// Total time with zero velocity [s].
+	dwellS float64
+	// Speed profile. Signs are the same as dx.
+	segments []MotionSegment
+	// Fraction of data points within the inlier threshold of the fitted speed,
+	// and RMS speed residual over those [px/s].
+	inlierFrac, residualPxS float64
+}
+
+// fitResiduals compares measured velocities v with fitted velocities vFit.
+// Returns the fraction of points within thresh, and the RMS residual over those.
+func fitResiduals(v, vFit []float64, thresh float64) (float64, float64) {
+	if len(v) == 0 {
+		return 0, 0
+	}
+
+	var n int
+	var sumSq float64
+	for i := range v {
+		r := v[i] - vFit[i]
+		if math.Abs(r) < thresh {
+			n++
+			sumSq += r * r
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+
+	return float64(n) / float64(len(v)), math.Sqrt(sumSq / float64(n))
+}
+
+// fitMotion fits a motion model to a sequence.
+// First, a piecewise model (segments of constant acceleration and dwell) is fitted.
+// If that results in only a single moving segment, a constant acceleration model is fitted using fitDx(),
+// which is more robust to outliers.
+// Does not modify seq.
+func fitMotion(seq sequence, maxSpeedPxS float64) (*motionFit, error) {
+	// Sanity checks.
+	if len(seq.dx) < (modelNParams+1)*3 {
+		return nil, errors.New("sequence length too short")
+	}
+
+	dt, t, v := prepareFit(seq)
+	thresh := maxSpeedPxS * 0.05 // Same as RANSAC inlier threshold.
+	segs := fitPiecewise(t, v, thresh)
+
+	if len(segs) == 1 && !segs[0].dwell {
+		dxFit, ds, v0, a, err := fitDx(seq, maxSpeedPxS)
+		if err != nil {
+			return nil, err
+		}
+
+		vFit := make([]float64, len(t))
+		for i := range t {
+			vFit[i] = model(t[i], []float64{v0, a})
+		}
+		inlierFrac, residual := fitResiduals(v, vFit, thresh)
+
+		// Estimate speed at halftime.
+		tMid := t[len(t)/2]
+		return &motionFit{
+			dx:    dxFit,
+			ds:    ds,
+			speed: v0 + a*tMid,
+			accel: a,
+			segments: []MotionSegment{{
+				StartS:    0,
+				EndS:      t[len(t)-1],
+				SpeedPxS:  v0,
+				AccelPxS2: a,
+			}},
+			inlierFrac:  inlierFrac,
+			residualPxS: residual,
+		}, nil
+	}
+
+	log.Info().Int("segments", len(segs)).Msg("using piecewise motion model")
+
+	ret := motionFit{}
+	dxF := make([]float64, len(t))
+	vFit := make([]float64, len(t))
+	var sumDx, movingS, longestS float64
+	for _, s := range segs {
+		for i := s.start; i < s.end; i++ {
+			vFit[i] = s.velocity(t[i])
+			dxF[i] = vFit[i] * dt[i]
+			sumDx += dxF[i]
+		}
+
+		durS := t[s.end-1] - s.t0
+		if s.dwell {
+			ret.dwellS += durS
+		} else {
+			movingS += durS
+			if durS > longestS {
+				longestS = durS
+				ret.accel = s.a
+			}
+		}
+
+		ret.segments = append(ret.segments, MotionSegment{
+			StartS:    s.t0,
+			EndS:      t[s.end-1],
+			SpeedPxS:  s.velocity(s.t0),
+			AccelPxS2: s.a,
+			Dwell:     s.dwell,
+		})
+	}
+
+	if movingS == 0 {
+		return nil, errors.New("no movement in sequence")
+	}
+
+	ret.dx = roundDx(dxF)
+	ret.inlierFrac, ret.residualPxS = fitResiduals(v, vFit, thresh)
+	ret.ds = math.Abs(sumDx)
+	ret.speed = sumDx / movingS
+	return &ret, nil
+}
@@ -17,8 +17,10 @@ func genTestSeq(dx []int) sequence {
 	seq := sequence{startTS: &t0}
 	for i, dx := range dx {
 		seq.frames = append(seq.frames, &image.RGBA{})
-		seq.dx = append(seq.dx, dx)
+		seq.dx = append(seq.dx, float64(dx))
+		seq.dy = append(seq.dy, 0)
 		seq.ts = append(seq.ts, t0.Add(time.Second/fps*time.Duration(i+1)))
+		seq.stats = append(seq.stats, frameStats{})
 	}
 	return seq
 }
@@ -113,3 +115,67 @@ func Test_fitDx_rounding(t *testing.T) {
 	truth := []int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10}
 	assert.Equal(t, truth, res)
 }
+
+func Test_fitMotion_single(t *testing.T) {
+	dx := []int{
+		9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9,
+		10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10,
+	}
+	seq := genTestSeq(dx)
+
+	fit, err := fitMotion(seq, 10*fps*2)
+	require.NoError(t, err)
+	res, ds, v0, a, err := fitDx(seq, 10*fps*2)
+	require.NoError(t, err)
+
+	// Must be identical to the constant acceleration model.
+	assert.Equal(t, res, fit.dx)
+	assert.Equal(t, ds, fit.ds)
+	assert.Equal(t, a, fit.accel)
+	assert.Equal(t, 0., fit.dwellS)
+	require.Len(t, fit.segments, 1)
+	assert.Equal(t, v0, fit.segments[0].SpeedPxS)
+	assert.False(t, fit.segments[0].Dwell)
+}
+
+func Test_fitMotion_stopAndGo(t *testing.T) {
+	// Decelerate from 30px/frame to 0, stand still for 2s, accelerate to 30px/frame.
+	var dx []int
+	for i := 0; i < 40; i++ {
+		dx = append(dx, 30-i*30/40)
+	}
+	for i := 0; i < 2*fps; i++ {
+		dx = append(dx, 0)
+	}
+	for i := 0; i < 40; i++ {
+		dx = append(dx, (i+1)*30/40)
+	}
+	truth := sumAbs(dx)
+	// Outliers.
+	dx[10] = 0
+	dx[130] = 2
+
+	fit, err := fitMotion(genTestSeq(dx), 35*fps*2)
+	require.NoError(t, err)
+	assert.Equal(t, len(dx), len(fit.dx))
+	assert.InDelta(t, truth, fit.ds, float64(truth)*0.02)
+	assert.InDelta(t, truth, sumAbs(fit.dx), float64(truth)*0.02)
+	assert.InDelta(t, 2, fit.dwellS, 0.3)
+	assert.Greater(t, fit.speed, 0.)
+
+	require.GreaterOrEqual(t, len(fit.segments), 3)
+	nDwell := 0
+	for _, s := range fit.segments {
+		if s.Dwell {
+			nDwell++
+		}
+	}
+	assert.Equal(t, 1, nDwell)
+	assert.Less(t, fit.segments[0].AccelPxS2, 0.)
+	assert.Greater(t, fit.segments[len(fit.segments)-1].AccelPxS2, 0.)
+
+	// Fitted values must have consistent sign so they can be stitched.
+	for _, x := range fit.dx {
+		assert.GreaterOrEqual(t, x, 0)
+	}
+}
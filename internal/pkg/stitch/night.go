@@ -0,0 +1,133 @@
+package stitch
+
+import (
+	"image"
+	"math"
+
+	"jo-m.ch/go/trainbot/pkg/avg"
+)
+
+const (
+	// Sequences with a mean brightness below this are considered to be recorded in darkness,
+	// and checked for false positives in night mode.
+	nightMaxBrightness = 0.15
+	// Max coefficient of variation of the frame brightness within a sequence.
+	// Headlights sweeping the scene make the whole frame brighten and darken again.
+	nightMaxBrightnessCV = 0.25
+	// Min mean contrast (average absolute deviation) of a sequence.
+	// A train is textured, a lit up patch of darkness is not.
+	nightMinContrast = 0.02
+	// Fraction of the brightest pixels of the stitched image,
+	// and the max share of the total luminance they might contribute.
+	// A train produces an evenly lit, textured image, while a light blob moving across the scene
+	// results in a bright smear on black.
+	nightLightPixelFraction = 0.05
+	nightMaxLightShare      = 0.5
+)
+
+// frameStats holds statistics of a frame.
+type frameStats struct {
+	// Brightness and contrast, averaged over the color channels, see avg.RGBAC().
+	brightness float64
+	contrast   float64
+	// Match score of the frame against the previous frame.
+	score float64
+}
+
+// newFrameStats computes brightness and contrast of a frame, score is left empty.
+func newFrameStats(frame *image.RGBA) frameStats {
+	avg, avgDev := avg.RGBAC(frame)
+	return frameStats{brightness: sum3(avg) / 3, contrast: sum3(avgDev) / 3}
+}
+
+// meanStd returns mean and standard deviation of the values.
+func meanStd(vals []float64) (float64, float64) {
+	if len(vals) == 0 {
+		return 0, 0
+	}
+
+	var sum, sumSq float64
+	for _, v := range vals {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(vals))
+	mean := sum / n
+	return mean, math.Sqrt(max(0, sumSq/n-mean*mean))
+}
+
+// isDark returns true if the sequence was recorded in darkness.
+func isDark(stats []frameStats) bool {
+	if len(stats) == 0 {
+		return false
+	}
+
+	brightness := make([]float64, len(stats))
+	for i, s := range stats {
+		brightness[i] = s.brightness
+	}
+	mean, _ := meanStd(brightness)
+	return mean < nightMaxBrightness
+}
+
+// nightCheckSequence checks a sequence recorded in darkness for typical false positives,
+// e.g. headlights sweeping the scene.
+// Returns the reason for rejection (to be used as prometheus label), or an empty string if the sequence looks fine.
+func nightCheckSequence(stats []frameStats) string {
+	if !isDark(stats) {
+		return ""
+	}
+
+	brightness := make([]float64, len(stats))
+	contrast := make([]float64, len(stats))
+	for i, s := range stats {
+		brightness[i] = s.brightness
+		contrast[i] = s.contrast
+	}
+
+	mean, std := meanStd(brightness)
+	if mean > 0 && std/mean > nightMaxBrightnessCV {
+		return "night_brightness_flicker"
+	}
+
+	meanContrast, _ := meanStd(contrast)
+	if meanContrast < nightMinContrast {
+		return "night_low_texture"
+	}
+
+	return ""
+}
+
+// nightCheckImage checks the stitched image of a sequence recorded in darkness for a light blob
+// which has moved across the scene, instead of a train.
+// Returns the reason for rejection (to be used as prometheus label), or an empty string if the image looks fine.
+func nightCheckImage(img *image.RGBA) string {
+	var hist [256]int
+	var total float64
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := range h {
+		row := img.Pix[y*img.Stride:]
+		for x := range w {
+			l := (299*int(row[x*4]) + 587*int(row[x*4+1]) + 114*int(row[x*4+2])) / 1000
+			hist[l]++
+			total += float64(l)
+		}
+	}
+	if total == 0 {
+		return "night_low_texture"
+	}
+
+	// Sum up the luminance of the brightest pixels.
+	remaining := int(math.Ceil(float64(w*h) * nightLightPixelFraction))
+	var light float64
+	for l := 255; l >= 0 && remaining > 0; l-- {
+		n := min(hist[l], remaining)
+		light += float64(n * l)
+		remaining -= n
+	}
+
+	if light/total > nightMaxLightShare {
+		return "night_light_blob"
+	}
+	return ""
+}
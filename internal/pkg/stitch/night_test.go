@@ -0,0 +1,75 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func constStats(n int, s frameStats) []frameStats {
+	ret := make([]frameStats, n)
+	for i := range ret {
+		ret[i] = s
+	}
+	return ret
+}
+
+func Test_nightCheckSequence(t *testing.T) {
+	// Daylight, never checked.
+	assert.Equal(t, "", nightCheckSequence(constStats(20, frameStats{brightness: 0.5, contrast: 0.001})))
+	assert.Equal(t, "", nightCheckSequence(nil))
+
+	// Dark, but a steady and textured scene.
+	assert.Equal(t, "", nightCheckSequence(constStats(20, frameStats{brightness: 0.05, contrast: 0.04})))
+
+	// Dark and low texture.
+	assert.Equal(t, "night_low_texture", nightCheckSequence(constStats(20, frameStats{brightness: 0.05, contrast: 0.015})))
+
+	// Headlights sweeping across a dark scene.
+	var sweep []frameStats
+	for _, b := range []float64{0.02, 0.03, 0.06, 0.12, 0.2, 0.12, 0.06, 0.03, 0.02} {
+		sweep = append(sweep, frameStats{brightness: b, contrast: 0.05})
+	}
+	assert.Equal(t, "night_brightness_flicker", nightCheckSequence(sweep))
+}
+
+func Test_nightCheckImage(t *testing.T) {
+	// Dark, but textured.
+	img := imutil.RandRGBA(123, 300, 60)
+	for i := range img.Pix {
+		if i%4 != 3 {
+			img.Pix[i] /= 4
+		}
+	}
+	assert.Equal(t, "", nightCheckImage(img))
+
+	// Bright smear on black.
+	img = image.NewRGBA(image.Rect(0, 0, 300, 60))
+	draw.Draw(img, image.Rect(0, 25, 300, 30), image.NewUniform(color.White), image.Point{}, draw.Src)
+	assert.Equal(t, "night_light_blob", nightCheckImage(img))
+
+	// All black.
+	assert.Equal(t, "night_low_texture", nightCheckImage(image.NewRGBA(image.Rect(0, 0, 300, 60))))
+}
+
+func Test_fitAndStitch_NightMode(t *testing.T) {
+	c := Config{
+		PixelsPerM:          10,
+		MinSpeedKPH:         10,
+		MaxSpeedKPH:         100,
+		MinLengthM:          1,
+		MaxFrameCountPerSeq: 100,
+		NightMode:           true,
+	}
+	seq := genTestSeq([]int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10})
+	seq.stats = constStats(len(seq.dx), frameStats{brightness: 0.05, contrast: 0.01})
+
+	_, err := fitAndStitch(seq, c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "night_low_texture")
+}
@@ -0,0 +1,163 @@
+package stitch
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	// Minimum number of data points per segment of the piecewise model.
+	piecewiseMinSegLen = (modelNParams + 1) * 2
+	// Maximum number of candidate segment boundaries, limits computational cost.
+	piecewiseMaxKnots = 32
+	// Iterations of inlier re-fitting per segment.
+	piecewiseRefitIter = 3
+)
+
+// pwSegment is a segment of a piecewise motion model, covering data points [start, end).
+// Velocity is v0 + a*(t - t0) where t0 is the time at the beginning of the segment.
+type pwSegment struct {
+	start, end int
+	dwell      bool
+	v0, a      float64
+	t0         float64
+}
+
+func (s *pwSegment) velocity(t float64) float64 {
+	if s.dwell {
+		return 0
+	}
+	return s.v0 + s.a*(t-s.t0)
+}
+
+// truncCost computes a truncated quadratic cost, which limits the influence of outliers.
+func truncCost(r, thresh float64) float64 {
+	return math.Min(r*r, thresh*thresh)
+}
+
+func median(x []float64) float64 {
+	s := slices.Clone(x)
+	slices.Sort(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// fitLineRobust fits v = v0 + a*(t - t0), starting from a robust two-group median estimate,
+// and then iteratively re-fitting by least squares on inliers.
+func fitLineRobust(t, v []float64, t0, thresh float64) (v0, a float64, ok bool) {
+	n := len(t)
+	if n < modelNParams+1 {
+		return 0, 0, false
+	}
+
+	// Initial estimate.
+	tl, tr := median(t[:n/2]), median(t[n/2:])
+	if tr > tl {
+		a = (median(v[n/2:]) - median(v[:n/2])) / (tr - tl)
+	}
+	res := make([]float64, n)
+	for i := range t {
+		res[i] = v[i] - a*(t[i]-t0)
+	}
+	v0 = median(res)
+
+	for iter := 0; iter < piecewiseRefitIter; iter++ {
+		var sw, st, sv, stt, stv float64
+		for i := range t {
+			if math.Abs(v0+a*(t[i]-t0)-v[i]) >= thresh {
+				continue
+			}
+			x := t[i] - t0
+			sw++
+			st += x
+			sv += v[i]
+			stt += x * x
+			stv += x * v[i]
+		}
+		if sw < modelNParams+1 {
+			return v0, a, iter > 0
+		}
+
+		det := sw*stt - st*st
+		if det == 0 {
+			return v0, a, iter > 0
+		}
+		a = (sw*stv - st*sv) / det
+		v0 = (sv - a*st) / sw
+	}
+
+	return v0, a, true
+}
+
+// fitSegment fits the best model (moving or dwell) to data points [start, end).
+// Returns the segment and its cost, including the model complexity penalty.
+func fitSegment(t, v []float64, start, end int, thresh, penalty float64) (pwSegment, float64) {
+	t0 := 0.
+	if start > 0 {
+		t0 = t[start-1]
+	}
+
+	dwell := pwSegment{start: start, end: end, dwell: true, t0: t0}
+	dwellCost := penalty
+	for i := start; i < end; i++ {
+		dwellCost += truncCost(v[i], thresh)
+	}
+
+	v0, a, ok := fitLineRobust(t[start:end], v[start:end], t0, thresh)
+	if !ok {
+		return dwell, dwellCost
+	}
+	moving := pwSegment{start: start, end: end, v0: v0, a: a, t0: t0}
+	movingCost := penalty * (1 + modelNParams)
+	for i := start; i < end; i++ {
+		movingCost += truncCost(v[i]-moving.velocity(t[i]), thresh)
+	}
+
+	if dwellCost <= movingCost {
+		return dwell, dwellCost
+	}
+	return moving, movingCost
+}
+
+// fitPiecewise fits a piecewise motion model, where each segment either has constant acceleration
+// or zero velocity (dwell). The number of segments and their boundaries are chosen by minimizing
+// a truncated quadratic cost plus a penalty per model parameter (similar to BIC).
+// Adjacent segments of the same kind are not merged.
+func fitPiecewise(t, v []float64, thresh float64) []pwSegment {
+	n := len(t)
+
+	// Candidate boundaries.
+	step := max(piecewiseMinSegLen, (n+piecewiseMaxKnots-1)/piecewiseMaxKnots)
+	knots := []int{0}
+	for k := step; k <= n-step; k += step {
+		knots = append(knots, k)
+	}
+	knots = append(knots, n)
+
+	penalty := thresh * thresh * math.Log(float64(n))
+
+	// best[i] is the minimum cost of data points [0, knots[i]).
+	best := make([]float64, len(knots))
+	prev := make([]int, len(knots))
+	seg := make([]pwSegment, len(knots))
+	for i := 1; i < len(knots); i++ {
+		best[i] = math.Inf(1)
+		for j := 0; j < i; j++ {
+			s, cost := fitSegment(t, v, knots[j], knots[i], thresh, penalty)
+			if best[j]+cost < best[i] {
+				best[i] = best[j] + cost
+				prev[i] = j
+				seg[i] = s
+			}
+		}
+	}
+
+	var ret []pwSegment
+	for i := len(knots) - 1; i > 0; i = prev[i] {
+		ret = append(ret, seg[i])
+	}
+	slices.Reverse(ret)
+	return ret
+}
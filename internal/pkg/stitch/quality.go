@@ -0,0 +1,61 @@
+package stitch
+
+import (
+	"math"
+)
+
+// Mean frame contrast at which contrast does not lower the quality score anymore.
+const qualityGoodContrast = 0.05
+
+// Quality describes how reliable the result of fitting and stitching a sequence is.
+type Quality struct {
+	// Fraction of frames whose measured speed agrees with the fitted motion model, [0, 1].
+	InlierFrac float64
+	// RMS difference between measured and fitted speed, over the inliers [px/s].
+	FitResidualPxS float64
+	// Mean and minimum match score between consecutive frames.
+	MeanScore float64
+	MinScore  float64
+	// Mean frame contrast (average absolute deviation), [0, 1].
+	Contrast float64
+	// RMS of the change of the measured dx between consecutive frames [px].
+	JitterPx float64
+}
+
+// Score summarizes the quality in a single value, [0, 1], higher is better.
+// It is the product of the inlier fraction, mean match score and contrast relative to qualityGoodContrast.
+func (q *Quality) Score() float64 {
+	contrast := min(1, q.Contrast/qualityGoodContrast)
+	return q.InlierFrac * max(0, min(1, q.MeanScore)) * contrast
+}
+
+// computeQuality computes the quality of a sequence, given the motion fitted to it.
+func computeQuality(seq sequence, fit *motionFit) Quality {
+	q := Quality{
+		InlierFrac:     fit.inlierFrac,
+		FitResidualPxS: fit.residualPxS,
+	}
+	if len(seq.stats) == 0 {
+		return q
+	}
+
+	q.MinScore = math.Inf(1)
+	for _, s := range seq.stats {
+		q.MeanScore += s.score
+		q.MinScore = min(q.MinScore, s.score)
+		q.Contrast += s.contrast
+	}
+	q.MeanScore /= float64(len(seq.stats))
+	q.Contrast /= float64(len(seq.stats))
+
+	if len(seq.dx) > 1 {
+		var sumSq float64
+		for i := 1; i < len(seq.dx); i++ {
+			d := seq.dx[i] - seq.dx[i-1]
+			sumSq += d * d
+		}
+		q.JitterPx = math.Sqrt(sumSq / float64(len(seq.dx)-1))
+	}
+
+	return q
+}
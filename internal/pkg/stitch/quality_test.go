@@ -0,0 +1,44 @@
+package stitch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fitResiduals(t *testing.T) {
+	frac, res := fitResiduals([]float64{10, 11, 9, 30}, []float64{10, 10, 10, 10}, 5)
+	assert.Equal(t, 0.75, frac)
+	assert.InDelta(t, 0.816, res, 0.001)
+
+	frac, res = fitResiduals(nil, nil, 5)
+	assert.Equal(t, 0., frac)
+	assert.Equal(t, 0., res)
+}
+
+func Test_computeQuality(t *testing.T) {
+	dx := []int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 30, 10, 10, 10, 10, 10, 10, 10, 10, 10}
+	seq := genTestSeq(dx)
+	for i := range seq.stats {
+		seq.stats[i] = frameStats{brightness: 0.5, contrast: 0.1, score: 0.99}
+	}
+	seq.stats[10].score = 0.9
+
+	fit, err := fitMotion(seq, 10*fps*2)
+	require.NoError(t, err)
+
+	q := computeQuality(seq, fit)
+	assert.InDelta(t, 0.95, q.InlierFrac, 1e-9)
+	assert.Less(t, q.FitResidualPxS, 1.)
+	assert.InDelta(t, 0.9855, q.MeanScore, 1e-9)
+	assert.Equal(t, 0.9, q.MinScore)
+	assert.InDelta(t, 0.1, q.Contrast, 1e-9)
+	// Two jumps of 20px out of 19 differences.
+	assert.InDelta(t, 6.489, q.JitterPx, 0.001)
+	assert.InDelta(t, 0.95*0.9855, q.Score(), 1e-9)
+
+	// Low contrast lowers the score.
+	q.Contrast = qualityGoodContrast / 2
+	assert.InDelta(t, 0.95*0.9855/2, q.Score(), 1e-9)
+}
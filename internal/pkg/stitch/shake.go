@@ -0,0 +1,43 @@
+package stitch
+
+import (
+	"math"
+)
+
+// Window size of the moving average used to separate camera shake from slow drift [frames].
+const shakeDriftWindow = 31
+
+// fitDy computes the vertical position of each frame from the per-frame offsets dy, compensating for camera shake.
+// Slow drift (including accumulated measurement errors) is removed by subtracting a centered moving average,
+// so that only the shake is corrected.
+// Returns the positions [px] (same length as dy) and the RMS of the shake [px].
+func fitDy(dy []float64) ([]int, float64) {
+	n := len(dy)
+	if n == 0 {
+		return nil, 0
+	}
+
+	// Integrate, and compute prefix sums for the moving average.
+	cum := make([]float64, n)
+	prefix := make([]float64, n+1)
+	acc := 0.
+	for i, d := range dy {
+		acc += d
+		cum[i] = acc
+		prefix[i+1] = prefix[i] + acc
+	}
+
+	ret := make([]int, n)
+	sumSq := 0.
+	for i := range cum {
+		lo := max(0, i-shakeDriftWindow/2)
+		hi := min(n, i+shakeDriftWindow/2+1)
+		drift := (prefix[hi] - prefix[lo]) / float64(hi-lo)
+
+		off := cum[i] - drift
+		sumSq += off * off
+		ret[i] = int(math.Round(off))
+	}
+
+	return ret, math.Sqrt(sumSq / float64(n))
+}
@@ -0,0 +1,62 @@
+package stitch
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func Test_fitDy(t *testing.T) {
+	y, shake := fitDy(nil)
+	assert.Empty(t, y)
+	assert.Zero(t, shake)
+
+	y, shake = fitDy(make([]float64, 50))
+	assert.Equal(t, make([]int, 50), y)
+	assert.Zero(t, shake)
+
+	// Oscillating camera, plus slow drift.
+	const n = 200
+	truth := make([]int, n)
+	dy := make([]float64, n)
+	prev := 0
+	for i := range truth {
+		truth[i] = int(math.Round(3 * math.Sin(float64(i)*math.Pi/4)))
+		drift := i / 40
+		dy[i] = float64(truth[i] + drift - prev)
+		prev = truth[i] + drift
+	}
+
+	y, shake = fitDy(dy)
+	require.Len(t, y, n)
+	assert.InDelta(t, 3/math.Sqrt2, shake, 0.4)
+	// Ignore the borders, where the moving average is not centered.
+	for i := shakeDriftWindow; i < n-shakeDriftWindow; i++ {
+		assert.InDelta(t, truth[i], y[i], 1, "i=%d", i)
+	}
+}
+
+func Test_stitch_dy(t *testing.T) {
+	tex := imutil.RandRGBA(123, 400, 60)
+	dx := []int{20, 20, 20, 20, 20}
+	y := []int{0, 2, -1, 1, 0}
+	var frames []image.Image
+	for i := range dx {
+		sub, err := imutil.Sub(tex, image.Rect(i*20, 10+y[i], i*20+100, 50+y[i]))
+		require.NoError(t, err)
+		frames = append(frames, imutil.ToRGBA(sub))
+	}
+
+	img, err := stitch(frames, dx, y, BlendNone)
+	require.NoError(t, err)
+
+	// Cropped vertically to the area covered by all frames.
+	assert.Equal(t, image.Rect(0, 0, 180, 37), img.Bounds())
+	expected, err := imutil.Sub(tex, image.Rect(0, 12, 180, 49))
+	require.NoError(t, err)
+	assert.Equal(t, imutil.ToRGBA(expected).Pix, img.Pix)
+}
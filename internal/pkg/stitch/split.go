@@ -0,0 +1,97 @@
+package stitch
+
+import (
+	"slices"
+)
+
+// Minimum number of frames moving in the same direction for a direction change to be detected.
+// Shorter runs are considered outliers.
+const splitMinRunLen = 5
+
+// signRun is a run of frames [start, end) in a sequence where all non-zero dx have the same sign.
+type signRun struct {
+	start, end int
+	sign       int
+	// Number of non-zero dx values in the run.
+	n int
+}
+
+// directionRuns finds runs of consistent movement direction in dx.
+// Runs with less than splitMinRunLen frames are iteratively dropped (shortest first),
+// and their neighbors merged if they have the same sign.
+// Adjacent runs in the result always have opposite signs.
+// Values which round to 0 are ignored.
+func directionRuns(dx []float64) []signRun {
+	var runs []signRun
+	for i, d := range dx {
+		s := isign(iround(d))
+		if s == 0 {
+			continue
+		}
+		if len(runs) > 0 && runs[len(runs)-1].sign == s {
+			runs[len(runs)-1].end = i + 1
+			runs[len(runs)-1].n++
+			continue
+		}
+		runs = append(runs, signRun{i, i + 1, s, 1})
+	}
+
+	for {
+		ix := -1
+		for i, r := range runs {
+			if r.n < splitMinRunLen && (ix < 0 || r.n < runs[ix].n) {
+				ix = i
+			}
+		}
+		if ix < 0 {
+			return runs
+		}
+
+		runs = slices.Delete(runs, ix, ix+1)
+		if ix > 0 && ix < len(runs) && runs[ix-1].sign == runs[ix].sign {
+			runs[ix-1].end = runs[ix].end
+			runs[ix-1].n += runs[ix].n
+			runs = slices.Delete(runs, ix, ix+1)
+		}
+	}
+}
+
+// splitSequence splits a sequence at points where the direction of movement changes,
+// e.g. because a train reversed, or two trains crossed in front of the camera.
+// Each returned sequence starts with a frame which has non-zero dx.
+// If there is no direction change, the sequence is returned as is.
+func splitSequence(seq sequence) []sequence {
+	runs := directionRuns(seq.dx)
+	if len(runs) < 2 {
+		return []sequence{seq}
+	}
+
+	ret := make([]sequence, 0, len(runs))
+	for i, r := range runs {
+		start := r.start
+		if i == 0 {
+			start = 0
+		}
+		end := len(seq.dx)
+		if i < len(runs)-1 {
+			end = runs[i+1].start
+		}
+
+		startTS := seq.startTS
+		if start > 0 {
+			ts := seq.ts[start-1]
+			startTS = &ts
+		}
+
+		ret = append(ret, sequence{
+			startTS: startTS,
+			frames:  seq.frames[start:end:end],
+			dx:      seq.dx[start:end:end],
+			dy:      seq.dy[start:end:end],
+			ts:      seq.ts[start:end:end],
+			stats:   seq.stats[start:end:end],
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,72 @@
+package stitch
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_directionRuns(t *testing.T) {
+	assert.Empty(t, directionRuns(nil))
+	assert.Empty(t, directionRuns([]float64{1, 2, 0, 3}))
+
+	assert.Equal(t,
+		[]signRun{{0, 8, 1, 6}},
+		directionRuns([]float64{5, 5, 0.2, 5, -5, 5, 5, 5}),
+	)
+
+	// Crossing, with outliers.
+	assert.Equal(t,
+		[]signRun{{0, 7, 1, 6}, {8, 14, -1, 5}},
+		directionRuns([]float64{5, 5, 5, -2, 5, 5, 5, 0, -5, -5, 3, -5, -5, -5}),
+	)
+
+	// Reversal after a stop.
+	assert.Equal(t,
+		[]signRun{{0, 5, -1, 5}, {8, 13, 1, 5}},
+		directionRuns([]float64{-3, -2, -2, -1, -1, 0.4, -0.3, 0, 1, 1, 2, 2, 3}),
+	)
+}
+
+func Test_splitSequence(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dx := []float64{-3, -2, -2, -1, -1, 0, 0, 0, 1, 1, 2, 2, 3}
+	seq := sequence{startTS: &t0}
+	for i, d := range dx {
+		seq.frames = append(seq.frames, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+		seq.dx = append(seq.dx, d)
+		seq.dy = append(seq.dy, float64(i%2))
+		seq.ts = append(seq.ts, t0.Add(time.Duration(i+1)*time.Second))
+		seq.stats = append(seq.stats, frameStats{brightness: float64(i)})
+	}
+
+	split := splitSequence(seq)
+	require.Len(t, split, 2)
+
+	assert.Equal(t, &t0, split[0].startTS)
+	assert.Equal(t, dx[:8], split[0].dx)
+	assert.Equal(t, seq.dy[:8], split[0].dy)
+	assert.Len(t, split[0].frames, 8)
+	assert.Equal(t, seq.ts[:8], split[0].ts)
+
+	assert.Equal(t, seq.ts[7], *split[1].startTS)
+	assert.Equal(t, dx[8:], split[1].dx)
+	assert.Len(t, split[1].frames, 5)
+	assert.Equal(t, seq.ts[8:], split[1].ts)
+	assert.Equal(t, seq.stats[8:], split[1].stats)
+
+	// Sub-sequences do not share spare capacity.
+	split[0].dx = append(split[0].dx, 99)
+	assert.Equal(t, 1., seq.dx[8])
+
+	// No direction change.
+	seq.dx = seq.dx[8:]
+	seq.dy = seq.dy[8:]
+	seq.frames = seq.frames[8:]
+	seq.ts = seq.ts[8:]
+	seq.stats = seq.stats[8:]
+	assert.Equal(t, []sequence{seq}, splitSequence(seq))
+}
@@ -7,6 +7,7 @@ import (
 	"image/draw"
 	"image/gif"
 	"math"
+	"slices"
 	"time"
 
 	"github.com/mccutchen/palettor"
@@ -17,6 +18,8 @@ import (
 
 const (
 	maxMemoryMB = 1024 * 1024 * 50
+	// Vertical offsets spanning more than 1/x of the frame height are not compensated.
+	maxShakeFrameFraction = 4
 )
 
 func isign(x int) int {
@@ -39,20 +42,43 @@ func sign(x float64) float64 {
 	return 0
 }
 
-func stitch(frames []image.Image, dx []int) (*image.RGBA, error) {
+// dxSign returns the direction of a sequence, i.e. the sign of all non-zero elements of dx.
+// Zeros are allowed anywhere, e.g. when the train stands still.
+func dxSign(dx []int) (int, error) {
+	sign := 0
+	for _, x := range dx {
+		if x == 0 {
+			continue
+		}
+		if sign != 0 && isign(x) != sign {
+			return 0, errors.New("dx elements do not have consistent sign")
+		}
+		sign = isign(x)
+	}
+	if sign == 0 {
+		return 0, errors.New("dx elements are all zero")
+	}
+	return sign, nil
+}
+
+// stitch assembles frames into one image.
+// dx are the horizontal offsets between frames, y the vertical positions of the frames (camera shake).
+// The image is cropped vertically to the area covered by all frames.
+// blend selects how overlapping frames are combined.
+func stitch(frames []image.Image, dx, y []int, blend BlendMode) (*image.RGBA, error) {
 	t0 := time.Now()
 	defer func() {
 		log.Trace().Dur("dur", time.Since(t0)).Msg("stitch() duration")
 	}()
 
-	log.Info().Ints("dx", dx).Int("len(frames)", len(frames)).Msg("stitch()")
+	log.Info().Ints("dx", dx).Ints("y", y).Int("len(frames)", len(frames)).Msg("stitch()")
 
 	// Sanity checks.
 	if len(dx) < 2 {
 		return nil, errors.New("sequence too short to stitch")
 	}
-	if len(frames) != len(dx) {
-		log.Panic().Msg("frames and dx do not have the same length, this should not happen")
+	if len(frames) != len(dx) || len(frames) != len(y) {
+		log.Panic().Msg("frames, dx and y do not have the same length, this should not happen")
 	}
 	fb := frames[0].Bounds()
 	for _, f := range frames {
@@ -61,38 +87,60 @@ func stitch(frames []image.Image, dx []int) (*image.RGBA, error) {
 		}
 	}
 
+	// Calculate height.
+	minY, maxY := slices.Min(y), slices.Max(y)
+	if maxY-minY > fb.Dy()/maxShakeFrameFraction {
+		log.Warn().Int("minY", minY).Int("maxY", maxY).Msg("vertical offsets too large, ignoring")
+		y = make([]int, len(y))
+		minY, maxY = 0, 0
+	}
+	h := fb.Dy() - (maxY - minY)
+
 	// Calculate base width.
-	sign := isign(dx[0])
+	sign, err := dxSign(dx)
+	if err != nil {
+		return nil, err
+	}
 	w := fb.Dx() * sign
-	h := fb.Dy()
 	for _, x := range dx[1:] {
-		if isign(x) != sign {
-			return nil, errors.New("dx elements do not have consistent sign")
-		}
 		w += x
 	}
 
 	// Memory alloc sanity check.
 	rect := image.Rect(0, 0, iabs(w), h)
-	if rect.Size().X*rect.Size().Y*4 > maxMemoryMB {
+	bytesPerPx := 4
+	if blend == BlendFeather {
+		// Additional float32 RGB accumulator.
+		bytesPerPx += 3 * 4
+	}
+	if rect.Size().X*rect.Size().Y*bytesPerPx > maxMemoryMB {
 		return nil, fmt.Errorf("would allocate too much memory: size %dx%d", rect.Size().X, rect.Size().Y)
 	}
 	img := image.NewRGBA(rect)
 
-	// Forward?
-	if w > 0 {
-		pos := 0
-		for i, f := range frames {
-			draw.Draw(img, img.Bounds().Add(image.Pt(pos, 0)), f, f.Bounds().Min, draw.Src)
-			pos += dx[i]
-		}
-	} else {
+	// Frame positions.
+	pos := make([]int, len(frames))
+	offY := make([]int, len(frames))
+	p := 0
+	if w < 0 {
 		// Backwards.
-		pos := -w - fb.Dx()
+		p = -w - fb.Dx()
+	}
+	for i := range frames {
+		pos[i] = p
+		offY[i] = y[i] - maxY
+		p += dx[i]
+	}
+
+	switch blend {
+	case BlendNone:
 		for i, f := range frames {
-			draw.Draw(img, img.Bounds().Add(image.Pt(pos, 0)), f, f.Bounds().Min, draw.Src)
-			pos += dx[i]
+			draw.Draw(img, image.Rectangle{Max: fb.Size()}.Add(image.Pt(pos[i], offY[i])), f, f.Bounds().Min, draw.Src)
 		}
+	case BlendFeather:
+		blendFeather(img, frames, dx, pos, offY, sign)
+	default:
+		return nil, fmt.Errorf("unknown blend mode %s", blend)
 	}
 
 	return img, nil
@@ -100,10 +148,17 @@ func stitch(frames []image.Image, dx []int) (*image.RGBA, error) {
 
 // Train represents a detected train.
 type Train struct {
+	// Site/camera identifier, empty for a single site.
+	Site string
+
 	StartTS time.Time
+	// Timestamp of the last frame.
+	EndTS time.Time
 
 	// Always positive.
 	NFrames int
+	// Mean frame rate of the sequence [1/s].
+	FPS float64
 
 	// Always positive (absolute value).
 	LengthPx float64
@@ -112,12 +167,48 @@ type Train struct {
 	// Positive sign means increasing speed for trains going to the right, breaking for trains going to the left.
 	AccelPxS2 float64
 
+	// Total time the train was standing still [s].
+	DwellS float64
+	// Speed profile, at least one element.
+	Segments []MotionSegment
+
+	// RMS of the vertical camera shake which was compensated [px].
+	ShakePx float64
+
+	Quality Quality
+	// True if Quality.Score() is below Config.MinQuality.
+	// Flagged trains are kept, but not published.
+	Flagged bool
+
+	// Scale of Image relative to the camera frames. Only < 1 in streaming mode, for very long trains.
+	ImageScale float64
+	// Cars (vehicles) segmented from Image, left to right. Might be a single car if no gaps were found.
+	Cars []Car
+
 	Conf Config
 
 	Image *image.RGBA `json:"-"`
 	GIF   *gif.GIF    `json:"-"`
 }
 
+// MotionSegment is a part of the motion of a train, with either constant acceleration or zero speed (dwell).
+type MotionSegment struct {
+	// Start and end time [s], relative to Train.StartTS.
+	StartS float64
+	EndS   float64
+	// Speed at the start of the segment, same sign convention as Train.SpeedPxS.
+	SpeedPxS float64
+	// Same sign convention as Train.AccelPxS2.
+	AccelPxS2 float64
+	// True if the train was standing still.
+	Dwell bool
+}
+
+// DurationS returns the time between the first and the last frame in s.
+func (t *Train) DurationS() float64 {
+	return t.EndTS.Sub(t.StartTS).Seconds()
+}
+
 // LengthM returns the absolute length in m.
 func (t *Train) LengthM() float64 {
 	return math.Abs(t.LengthPx) / t.Conf.PixelsPerM
@@ -193,11 +284,11 @@ func fitAndStitch(seq sequence, c Config) (*Train, error) {
 		log.Trace().Dur("dur", time.Since(start)).Msg("fitAndStitch() duration")
 	}()
 
-	log.Info().Ints("dx", seq.dx).Int("len(frames)", len(seq.frames)).Msg("fitAndStitch()")
+	log.Info().Floats64("dx", seq.dx).Int("len(frames)", len(seq.frames)).Msg("fitAndStitch()")
 
 	// Sanity checks.
-	if len(seq.frames) != len(seq.dx) || len(seq.frames) != len(seq.ts) {
-		log.Panic().Msg("length of frames, dx, ts are not equal, this should not happen")
+	if len(seq.frames) != len(seq.dx) || len(seq.frames) != len(seq.dy) || len(seq.frames) != len(seq.ts) || len(seq.frames) != len(seq.stats) {
+		log.Panic().Msg("length of frames, dx, dy, ts, stats are not equal, this should not happen")
 	}
 	if seq.startTS == nil {
 		log.Panic().Msg("startTS is nil, this should not happen")
@@ -207,52 +298,111 @@ func fitAndStitch(seq sequence, c Config) (*Train, error) {
 	}
 
 	// Remove trailing zeros.
-	for len(seq.dx) > 0 && seq.dx[len(seq.dx)-1] == 0 {
+	for len(seq.dx) > 0 && iround(seq.dx[len(seq.dx)-1]) == 0 {
 		seq.dx = seq.dx[:len(seq.dx)-1]
+		seq.dy = seq.dy[:len(seq.dy)-1]
 		seq.ts = seq.ts[:len(seq.ts)-1]
 		seq.frames = seq.frames[:len(seq.frames)-1]
+		seq.stats = seq.stats[:len(seq.stats)-1]
 	}
 	prometheus.RecordSequenceLength(len(seq.frames))
 
-	dxFit, ds, v0, a, err := fitDx(seq, float64(c.maxPxPerFrame(1)))
+	if c.NightMode {
+		if reason := nightCheckSequence(seq.stats); reason != "" {
+			prometheus.RecordFitAndStitchResult(reason)
+			return nil, fmt.Errorf("discarded in night mode: %s", reason)
+		}
+	}
+
+	fit, err := fitMotion(seq, float64(c.maxPxPerFrame(1)))
 	if err != nil {
 		prometheus.RecordFitAndStitchResult("unable_to_fit")
 		return nil, fmt.Errorf("was not able to fit the sequence: %w", err)
 	}
 
-	if math.Abs(ds) < c.minLengthPx() {
+	if fit.ds < c.minLengthPx() {
 		prometheus.RecordFitAndStitchResult("too_short")
-		return nil, fmt.Errorf("discarded because too short, %f < %f", ds, c.minLengthPx())
+		return nil, fmt.Errorf("discarded because too short, %f < %f", fit.ds, c.minLengthPx())
 	}
 
-	// Estimate speed at halftime.
-	t0 := seq.ts[0]
-	tMid := seq.ts[len(seq.ts)/2]
-	speed := v0 + a*tMid.Sub(t0).Seconds()
-
-	if math.Abs(speed) < c.minSpeedPxPS() {
+	if math.Abs(fit.speed) < c.minSpeedPxPS() {
 		prometheus.RecordFitAndStitchResult("too_slow")
-		return nil, fmt.Errorf("discarded because too slow, %f < %f", speed, c.minSpeedPxPS())
+		return nil, fmt.Errorf("discarded because too slow, %f < %f", fit.speed, c.minSpeedPxPS())
 	}
 
-	img, err := stitch(seq.frames, dxFit)
+	y, shake := fitDy(seq.dy)
+	var img *image.RGBA
+	var streamed *streamStitched
+	scale := 1.
+	if c.StreamDir != "" {
+		streamed, err = stitchStrips(seq.frames, fit.dx, y, streamMaxWidthPx)
+		if err == nil {
+			img, scale = streamed.img, streamed.scale
+		}
+	} else {
+		img, err = stitch(seq.frames, fit.dx, y, c.Blend)
+	}
 	if err != nil {
 		prometheus.RecordFitAndStitchResult("unable_to_assemble_image")
 		return nil, fmt.Errorf("unable to assemble image: %w", err)
 	}
 
-	gif, err := createGIF(seq, img)
+	if c.NightMode && isDark(seq.stats) {
+		if reason := nightCheckImage(img); reason != "" {
+			prometheus.RecordFitAndStitchResult(reason)
+			return nil, fmt.Errorf("discarded in night mode: %s", reason)
+		}
+	}
+
+	var gif *gif.GIF
+	if streamed != nil {
+		gif, err = createPanGIF(seq, streamed)
+	} else {
+		gif, err = createGIF(seq, img)
+	}
 	if err != nil {
 		panic(err)
 	}
 
-	prometheus.RecordFitAndStitchResult("success")
+	// Negate because when things move to the left we get positive dx values.
+	segments := make([]MotionSegment, len(fit.segments))
+	for i, s := range fit.segments {
+		s.SpeedPxS = -s.SpeedPxS
+		s.AccelPxS2 = -s.AccelPxS2
+		segments[i] = s
+	}
+
+	cars := segmentCars(img, c.PixelsPerM*scale)
+	log.Debug().Int("n", len(cars)).Msg("segmented cars")
+
+	quality := computeQuality(seq, fit)
+	flagged := quality.Score() < c.MinQuality
+	if flagged {
+		log.Warn().Interface("quality", quality).Float64("score", quality.Score()).Msg("low quality, flagging train")
+		prometheus.RecordFitAndStitchResult("flagged")
+	} else {
+		prometheus.RecordFitAndStitchResult("success")
+	}
+
+	endTS := seq.ts[len(seq.ts)-1]
+	fps := float64(len(seq.ts)) / endTS.Sub(*seq.startTS).Seconds()
+
 	return &Train{
-		t0,
+		c.Site,
+		seq.ts[0],
+		endTS,
 		len(seq.frames),
-		ds,
-		-speed, // Negate because when things move to the left we get positive dx values.
-		-a,
+		fps,
+		fit.ds,
+		-fit.speed,
+		-fit.accel,
+		fit.dwellS,
+		segments,
+		shake,
+		quality,
+		flagged,
+		scale,
+		cars,
 		c,
 		img,
 		gif,
@@ -0,0 +1,371 @@
+package stitch
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/mccutchen/palettor"
+	"github.com/nfnt/resize"
+	"github.com/rs/zerolog/log"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+const (
+	// Margin on both sides of the strip kept from each frame in streaming mode, beyond |dx| [px].
+	// Leaves room for the fitted dx to differ from the measured one.
+	streamStripMarginPx = 8
+	// Max. width of the stitched image in streaming mode, longer trains are scaled down (limit of the JPEG format).
+	streamMaxWidthPx = 65535
+	// Max. number of frames in the GIF created in streaming mode.
+	streamMaxGIFFrames = 300
+)
+
+// spool is a temporary file holding frame strips in streaming mode.
+type spool struct {
+	f    *os.File
+	size int64
+}
+
+func newSpool(dir string) (*spool, error) {
+	f, err := os.CreateTemp(dir, "trainbot-spool-*.raw")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{f: f}, nil
+}
+
+// write appends the part of frame within rect (which must be within the frame bounds) to the spool.
+func (s *spool) write(frame image.Image, rect image.Rectangle) (*spooledStrip, error) {
+	strip := image.NewRGBA(rect)
+	draw.Draw(strip, rect, frame, rect.Min, draw.Src)
+
+	_, err := s.f.WriteAt(strip.Pix, s.size)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &spooledStrip{
+		sp:    s,
+		off:   s.size,
+		rect:  rect,
+		frame: frame.Bounds(),
+	}
+	s.size += int64(len(strip.Pix))
+	return ret, nil
+}
+
+// close closes and deletes the spool file.
+func (s *spool) close() error {
+	return errors.Join(s.f.Close(), os.Remove(s.f.Name()))
+}
+
+// spooledStrip is a vertical strip of a frame, stored in a spool.
+// It implements image.Image, with the bounds of the strip within the original frame,
+// but pixel access via At() is slow, use load() instead.
+type spooledStrip struct {
+	sp  *spool
+	off int64
+	// Part of the frame which is stored.
+	rect image.Rectangle
+	// Bounds of the original frame.
+	frame image.Rectangle
+}
+
+// ColorModel implements image.Image.
+func (s *spooledStrip) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds implements image.Image.
+func (s *spooledStrip) Bounds() image.Rectangle {
+	return s.rect
+}
+
+// At implements image.Image.
+func (s *spooledStrip) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(s.rect)) {
+		return color.RGBA{}
+	}
+
+	var px [4]byte
+	off := s.off + int64(((y-s.rect.Min.Y)*s.rect.Dx()+(x-s.rect.Min.X))*4)
+	_, err := s.sp.f.ReadAt(px[:], off)
+	if err != nil {
+		log.Panic().Err(err).Msg("failed to read from spool, this should not happen")
+	}
+	return color.RGBA{px[0], px[1], px[2], px[3]}
+}
+
+// load reads the strip from the spool.
+func (s *spooledStrip) load() (*image.RGBA, error) {
+	ret := image.NewRGBA(s.rect)
+	_, err := s.sp.f.ReadAt(ret.Pix, s.off)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// stripRect returns the central strip of a frame with the given bounds which is kept in streaming mode,
+// given the dx measured for it.
+func stripRect(frame image.Rectangle, dx float64) image.Rectangle {
+	half := iabs(iround(dx)) + streamStripMarginPx
+	cx := frame.Min.X + frame.Dx()/2
+	return image.Rect(cx-half, frame.Min.Y, cx+half, frame.Max.Y).Intersect(frame)
+}
+
+// keepFrame returns what to store in the sequence for frame.
+// In streaming mode, that is the central strip of the frame, spooled to disk.
+// If spooling fails, the full frame is kept.
+func (r *AutoStitcher) keepFrame(frame image.Image, dx float64) image.Image {
+	if r.c.StreamDir == "" {
+		return frame
+	}
+	if _, ok := frame.(*spooledStrip); ok {
+		return frame
+	}
+
+	if r.spool == nil {
+		sp, err := newSpool(r.c.StreamDir)
+		if err != nil {
+			log.Err(err).Str("dir", r.c.StreamDir).Msg("failed to create spool, keeping full frame")
+			return frame
+		}
+		r.spool = sp
+	}
+
+	strip, err := r.spool.write(frame, stripRect(frame.Bounds(), dx))
+	if err != nil {
+		log.Err(err).Msg("failed to spool frame, keeping full frame")
+		return frame
+	}
+	return strip
+}
+
+// closeSpool deletes the spool, if any.
+// Frames of the current sequence which were spooled can not be used anymore afterwards.
+func (r *AutoStitcher) closeSpool() {
+	if r.spool == nil {
+		return
+	}
+
+	err := r.spool.close()
+	if err != nil {
+		log.Err(err).Msg("failed to delete spool")
+	}
+	r.spool = nil
+}
+
+// frameBounds returns the bounds of the original frame for f, which might be a spooled strip.
+func frameBounds(f image.Image) image.Rectangle {
+	if s, ok := f.(*spooledStrip); ok {
+		return s.frame
+	}
+	return f.Bounds()
+}
+
+// loadFrame returns the pixels of f, which might be a spooled strip.
+func loadFrame(f image.Image) (image.Image, error) {
+	if s, ok := f.(*spooledStrip); ok {
+		return s.load()
+	}
+	return f, nil
+}
+
+// streamStitched is the result of stitchStrips.
+type streamStitched struct {
+	img *image.RGBA
+	// Scale of img relative to the frames, < 1 if it had to be scaled down.
+	scale float64
+	// Horizontal position of the left edge of each frame on img, before scaling.
+	frameX []int
+	// Size of the frames.
+	frameSize image.Point
+}
+
+// stitchStrips assembles frames into one image like stitch(), but only uses a strip of each frame:
+// frame i covers the area halfway between its center and the centers of frames i-1 and i+1.
+// Frames can be spooled strips (see keepFrame()), which are loaded one after the other.
+// The image only extends to the outer edges of the strips of the first and last frames.
+// If it would use too much memory or be wider than maxWidthPx, it is scaled down, strip by strip.
+func stitchStrips(frames []image.Image, dx, y []int, maxWidthPx int) (*streamStitched, error) {
+	t0 := time.Now()
+	defer func() {
+		log.Trace().Dur("dur", time.Since(t0)).Msg("stitchStrips() duration")
+	}()
+
+	log.Info().Ints("dx", dx).Ints("y", y).Int("len(frames)", len(frames)).Msg("stitchStrips()")
+
+	// Sanity checks.
+	if len(dx) < 2 {
+		return nil, errors.New("sequence too short to stitch")
+	}
+	if len(frames) != len(dx) || len(frames) != len(y) {
+		log.Panic().Msg("frames, dx and y do not have the same length, this should not happen")
+	}
+	fb := frameBounds(frames[0])
+	for _, f := range frames {
+		if frameBounds(f) != fb {
+			log.Panic().Msg("frame bounds or size not consistent, this should not happen")
+		}
+	}
+	sign := isign(dx[0])
+	for _, x := range dx[1:] {
+		// Zero is allowed, e.g. when the train stands still.
+		if x != 0 && isign(x) != sign {
+			return nil, errors.New("dx elements do not have consistent sign")
+		}
+	}
+
+	// Calculate height.
+	minY, maxY := slices.Min(y), slices.Max(y)
+	if maxY-minY > fb.Dy()/maxShakeFrameFraction {
+		log.Warn().Int("minY", minY).Int("maxY", maxY).Msg("vertical offsets too large, ignoring")
+		y = make([]int, len(y))
+		minY, maxY = 0, 0
+	}
+	h := fb.Dy() - (maxY - minY)
+
+	// Frame positions, and the area [lo, hi) each frame covers, relative to the first frame.
+	cx := fb.Dx() / 2
+	pos := make([]int, len(frames))
+	lo := make([]int, len(frames))
+	hi := make([]int, len(frames))
+	p := 0
+	for i, f := range frames {
+		pos[i] = p
+		// Boundaries to the previous and next frame. Where there is none, the strip extends as far as available.
+		avail := f.Bounds()
+		bPrev, bNext := avail.Min.X-fb.Min.X+p, avail.Max.X-fb.Min.X+p
+		if sign < 0 {
+			bPrev, bNext = bNext, bPrev
+		}
+		if i > 0 {
+			// Same as bNext of the previous frame.
+			bPrev = pos[i-1] + cx + dx[i-1]/2
+		}
+		if i < len(frames)-1 {
+			bNext = p + cx + dx[i]/2
+		}
+		lo[i], hi[i] = min(bPrev, bNext), max(bPrev, bNext)
+		p += dx[i]
+	}
+	minX, maxX := slices.Min(lo), slices.Max(hi)
+	w := maxX - minX
+
+	// Scale down if necessary.
+	scale := 1.
+	if w*h*4 > maxMemoryMB {
+		scale = math.Sqrt(float64(maxMemoryMB) / float64(w*h*4))
+	}
+	scale = min(scale, float64(maxWidthPx)/float64(w))
+	sw, sh := max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+	if scale < 1 {
+		log.Warn().Int("w", w).Int("h", h).Float64("scale", scale).Msg("image too large, scaling down")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, sw, sh))
+
+	gaps := 0
+	for i, f := range frames {
+		if lo[i] == hi[i] {
+			continue
+		}
+
+		px, err := loadFrame(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load frame %d: %w", i, err)
+		}
+
+		// Area to copy, in frame coordinates.
+		src := image.Rect(lo[i]-pos[i], maxY-y[i], hi[i]-pos[i], maxY-y[i]+h).Add(fb.Min)
+		if !src.In(px.Bounds()) {
+			gaps++
+			src = src.Intersect(px.Bounds())
+		}
+		dstX0 := src.Min.X - fb.Min.X + pos[i] - minX
+
+		if scale == 1 {
+			dst := image.Rect(dstX0, 0, dstX0+src.Dx(), h)
+			draw.Draw(img, dst, px, src.Min, draw.Src)
+			continue
+		}
+
+		x0, x1 := iround(float64(dstX0)*scale), iround(float64(dstX0+src.Dx())*scale)
+		if x1 <= x0 {
+			continue
+		}
+		sub, err := imutil.Sub(px, src)
+		if err != nil {
+			log.Panic().Err(err).Msg("this should not happen")
+		}
+		scaled := resize.Resize(uint(x1-x0), uint(sh), sub, resize.Bilinear)
+		draw.Draw(img, image.Rect(x0, 0, x1, sh), scaled, scaled.Bounds().Min, draw.Src)
+	}
+	if gaps > 0 {
+		log.Warn().Int("n", gaps).Msg("strips did not cover the full image, speed changed too fast")
+	}
+
+	frameX := make([]int, len(frames))
+	for i := range frames {
+		frameX[i] = pos[i] - minX
+	}
+
+	return &streamStitched{
+		img:       img,
+		scale:     scale,
+		frameX:    frameX,
+		frameSize: fb.Size(),
+	}, nil
+}
+
+// createPanGIF creates a GIF for a sequence stitched by stitchStrips(), without needing the full frames:
+// Each GIF frame shows the area of the stitched image where the corresponding sequence frame was.
+func createPanGIF(seq sequence, s *streamStitched) (*gif.GIF, error) {
+	thumb := resize.Thumbnail(300, 300, s.img, resize.Lanczos3)
+	const (
+		paletteSize = 20
+		nIter       = 100
+	)
+	pal, err := palettor.Extract(paletteSize, nIter, thumb)
+	if err != nil {
+		return nil, err
+	}
+
+	g := gif.GIF{}
+
+	// Skip at least every other frame, more for long sequences.
+	skip := max(2, (len(seq.ts)+streamMaxGIFFrames-1)/streamMaxGIFFrames)
+	win := image.Rectangle{Max: s.frameSize}
+	win.Max.X = iround(float64(win.Max.X) * s.scale)
+	win.Max.Y = s.img.Rect.Dy()
+
+	prevTS := *seq.startTS
+	for i, ts := range seq.ts {
+		dt := ts.Sub(prevTS)
+
+		if i%skip != 0 {
+			continue
+		}
+
+		x := iround(float64(s.frameX[i]) * s.scale)
+		x = max(0, min(s.img.Rect.Dx()-win.Dx(), x))
+
+		paletted := image.NewPaletted(win, pal.Colors())
+		draw.Draw(paletted, paletted.Bounds(), s.img, image.Pt(x, 0), draw.Src)
+
+		g.Image = append(g.Image, paletted)
+		g.Delay = append(g.Delay, int(dt.Seconds()*100))
+
+		prevTS = ts
+	}
+
+	return &g, nil
+}
@@ -0,0 +1,156 @@
+package stitch
+
+import (
+	"image"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func Test_stripRect(t *testing.T) {
+	frame := image.Rect(10, 5, 110, 45)
+	assert.Equal(t, image.Rect(60-20-streamStripMarginPx, 5, 60+20+streamStripMarginPx, 45), stripRect(frame, -20.3))
+	assert.Equal(t, image.Rect(60-streamStripMarginPx, 5, 60+streamStripMarginPx, 45), stripRect(frame, 0))
+	assert.Equal(t, frame, stripRect(frame, 200))
+}
+
+func Test_spool(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := newSpool(dir)
+	require.NoError(t, err)
+
+	frames := []*image.RGBA{imutil.RandRGBA(1, 100, 40), imutil.RandRGBA(2, 100, 40)}
+	var strips []*spooledStrip
+	for i, f := range frames {
+		s, err := sp.write(f, stripRect(f.Rect, float64(10*i)))
+		require.NoError(t, err)
+		strips = append(strips, s)
+	}
+
+	for i, s := range strips {
+		assert.Equal(t, frames[i].Rect, frameBounds(s))
+		loaded, err := s.load()
+		require.NoError(t, err)
+		assert.Equal(t, s.Bounds(), loaded.Rect)
+		assert.Equal(t, frames[i].SubImage(s.Bounds()).(*image.RGBA).At(52, 7), loaded.At(52, 7))
+		assert.Equal(t, frames[i].At(45, 30), s.At(45, 30))
+		for y := s.rect.Min.Y; y < s.rect.Max.Y; y++ {
+			for x := s.rect.Min.X; x < s.rect.Max.X; x++ {
+				require.Equal(t, frames[i].RGBAAt(x, y), loaded.RGBAAt(x, y))
+			}
+		}
+	}
+
+	require.NoError(t, sp.close())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// assertMatchesFrames asserts that each frame, placed at its position, matches the stitched image.
+func assertMatchesFrames(t *testing.T, frames []image.Image, s *streamStitched) {
+	t.Helper()
+
+	for i, f := range frames {
+		fb := f.Bounds()
+		for u := range fb.Dx() {
+			x := s.frameX[i] + u
+			if x < 0 || x >= s.img.Rect.Dx() {
+				continue
+			}
+			for v := range fb.Dy() {
+				require.Equal(t, f.At(fb.Min.X+u, fb.Min.Y+v), s.img.At(x, v), "frame %d u=%d v=%d", i, u, v)
+			}
+		}
+	}
+}
+
+func Test_stitchStrips(t *testing.T) {
+	for _, dx := range []int{20, -20} {
+		frames, dxs, y := blendTestFrames(dx, make([]int, 6), false)
+
+		// Full frames, the first and last cover everything on their outer side.
+		full, err := stitchStrips(frames, dxs, y, streamMaxWidthPx)
+		require.NoError(t, err)
+		assert.Equal(t, 1., full.scale)
+		assert.Equal(t, image.Pt(100, 40), full.frameSize)
+		assert.Equal(t, image.Rect(0, 0, 5*20+100, 40), full.img.Rect)
+		assertMatchesFrames(t, frames, full)
+
+		// Spooled strips match as well, but the image only extends up to the outer edges of the first and last strips.
+		sp, err := newSpool(t.TempDir())
+		require.NoError(t, err)
+		strips := make([]image.Image, len(frames))
+		for i, f := range frames {
+			strips[i], err = sp.write(f, stripRect(f.Bounds(), float64(dx)))
+			require.NoError(t, err)
+		}
+		streamed, err := stitchStrips(strips, dxs, y, streamMaxWidthPx)
+		require.NoError(t, err)
+		require.NoError(t, sp.close())
+		assert.Equal(t, image.Rect(0, 0, 5*20+2*(20+streamStripMarginPx), 40), streamed.img.Rect)
+		assertMatchesFrames(t, frames, streamed)
+
+		// Scaled down.
+		scaled, err := stitchStrips(frames, dxs, y, 100)
+		require.NoError(t, err)
+		assert.Equal(t, 0.5, scaled.scale)
+		assert.Equal(t, image.Rect(0, 0, 100, 20), scaled.img.Rect)
+	}
+
+	frames, _, y := blendTestFrames(20, make([]int, 3), false)
+	_, err := stitchStrips(frames, []int{20, -20, 20}, y, streamMaxWidthPx)
+	assert.Error(t, err)
+}
+
+func Test_AutoStitcher_Stream(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{
+		PixelsPerM:  10,
+		MinSpeedKPH: 10,
+		MaxSpeedKPH: 100,
+		MinLengthM:  1,
+		// Would force-end the sequence many times without streaming.
+		MaxFrameCountPerSeq: 5,
+		StreamDir:           dir,
+	}
+	frames := slidingFrames(40, 3, 200, 40, 8)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	r := NewAutoStitcher(c)
+	defer r.Destroy()
+	var trains []*Train
+	for i, f := range frames {
+		trains = append(trains, r.Frame(f, start.Add(time.Duration(i)*100*time.Millisecond))...)
+	}
+	require.Empty(t, trains)
+
+	// Only strips are kept.
+	require.Len(t, r.seq.frames, 37)
+	for _, f := range r.seq.frames {
+		require.IsType(t, &spooledStrip{}, f)
+		assert.Less(t, f.Bounds().Dx(), 50)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	trains = r.TryStitchAndReset()
+	require.Len(t, trains, 1)
+	train := trains[0]
+	assert.Equal(t, 37, train.NFrames)
+	assert.InDelta(t, 8*37, train.LengthPx, 2)
+	assert.Equal(t, 1., train.ImageScale)
+	assert.Equal(t, 40, train.Image.Rect.Dy())
+	assert.InDelta(t, 8*36+2*(8+streamStripMarginPx), train.Image.Rect.Dx(), 4)
+	assert.Len(t, train.GIF.Image, 19)
+
+	// The spool is deleted.
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
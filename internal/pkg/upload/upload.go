@@ -120,9 +120,33 @@ func All(ctx context.Context, store DataStore, dbx *sqlx.DB, uploader Uploader)
 		return 0, err
 	}
 
+	// Flagged trains are not published.
+	err = deleteFlagged(store.GetDataPath(dbBakFile))
+	if err != nil {
+		log.Err(err).Send()
+		return 0, err
+	}
+
 	return nUploads, uploadFile(ctx, uploader, store.GetDataPath(dbBakFile), dbFile, true)
 }
 
+// deleteFlagged removes flagged trains from the database at path.
+func deleteFlagged(path string) error {
+	dbx, err := db.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dbx.Close()
+
+	n, err := db.DeleteFlagged(dbx)
+	if err != nil {
+		return err
+	}
+	log.Debug().Int64("n", n).Msg("removed flagged trains from db backup")
+
+	return nil
+}
+
 // CleanupOrphanedRemoteBlobs removes from the remote storage all blobs which are unknown to the database.
 func CleanupOrphanedRemoteBlobs(ctx context.Context, dbx *sqlx.DB, uploader Uploader) (int, error) {
 	// Get list of blobs from remote.
@@ -1,5 +1,7 @@
 #include "c.h"
 
+#include <math.h>
+
 static const int four = 4;
 
 void SearchRGBAC(const int m, const int n, const int du, const int dv,
@@ -52,3 +54,112 @@ void SearchRGBAC(const int m, const int n, const int du, const int dv,
     }
   }
 }
+
+float64 ScoreRGBACos2C(const int du, const int dv, const int is, const int ps,
+                       /* pixels */
+                       const uint8_t* const imgPix, const uint8_t* const patPix) {
+  uint64_t dot = 0, absI2 = 0, absP2 = 0;
+
+  for (int v = 0; v < dv; v++) {
+    int pxIi = v * is;
+    int pxPi = v * ps;
+
+    for (int u = 0; u < du; u++) {
+      for (int rgb = 0; rgb < 3; rgb++) {
+        const int pxI = imgPix[pxIi + u * four + rgb];
+        const int pxP = patPix[pxPi + u * four + rgb];
+
+        dot += (uint64_t)(pxI) * (uint64_t)(pxP);
+        absI2 += (uint64_t)(pxI) * (uint64_t)(pxI);
+        absP2 += (uint64_t)(pxP) * (uint64_t)(pxP);
+      }
+    }
+  }
+
+  const float64 abs2 = (float64)(absI2) * (float64)(absP2);
+  if (abs2 == 0) {
+    return 1;
+  }
+  return (float64)dot * (float64)dot / abs2;
+}
+
+float64 ScoreModeC(const int du, const int dv, const int is, const int ps,
+                   const int bpp, const int nc, const int zeroMean,
+                   /* pixels */
+                   const uint8_t* const imgPix, const uint8_t* const patPix) {
+  uint64_t dot = 0, sI = 0, sP = 0, sI2 = 0, sP2 = 0;
+
+  for (int v = 0; v < dv; v++) {
+    int pxIi = v * is;
+    int pxPi = v * ps;
+
+    for (int u = 0; u < du; u++) {
+      for (int c = 0; c < nc; c++) {
+        const uint64_t pxI = imgPix[pxIi + u * bpp + c];
+        const uint64_t pxP = patPix[pxPi + u * bpp + c];
+
+        dot += pxI * pxP;
+        sI += pxI;
+        sP += pxP;
+        sI2 += pxI * pxI;
+        sP2 += pxP * pxP;
+      }
+    }
+  }
+
+  if (!zeroMean) {
+    const float64 abs2 = (float64)(sI2) * (float64)(sP2);
+    if (abs2 == 0) {
+      return 1;
+    }
+    return (float64)dot / sqrt(abs2);
+  }
+
+  const float64 cnt = (float64)du * (float64)dv * (float64)nc;
+  const float64 num = (float64)dot - (float64)sI * (float64)sP / cnt;
+  const float64 varI = (float64)sI2 - (float64)sI * (float64)sI / cnt;
+  const float64 varP = (float64)sP2 - (float64)sP * (float64)sP / cnt;
+  if (varI <= 0 && varP <= 0) {
+    // Both flat.
+    return 1;
+  }
+  if (varI <= 0 || varP <= 0) {
+    return 0;
+  }
+  return num / sqrt(varI * varP);
+}
+
+void SearchModeC(const int m, const int n, const int du, const int dv,
+                 const int is, const int ps, const int bpp, const int nc,
+                 const int zeroMean,
+                 /* pixels */
+                 const uint8_t* const imgPix, const uint8_t* const patPix,
+                 /* return parameters */
+                 int* maxX, int* maxY, float64* maxScore) {
+#ifdef _OPENMP
+#pragma omp parallel for collapse(2)
+#endif
+  for (int y = 0; y < n; y++) {
+    for (int x = 0; x < m; x++) {
+      const float64 score = ScoreModeC(du, dv, is, ps, bpp, nc, zeroMean,
+                                       imgPix + y * is + x * bpp, patPix);
+
+#ifdef _OPENMP
+#pragma omp critical
+#endif
+      if (score > *maxScore) {
+        *maxScore = score;
+        *maxX = x;
+        *maxY = y;
+      }
+    }
+  }
+}
+
+int HasOpenMPC(void) {
+#ifdef _OPENMP
+  return 1;
+#else
+  return 0;
+#endif
+}
@@ -15,6 +15,7 @@ package pmatch
 
 // #cgo CFLAGS: -Wall -Werror -Wextra -pedantic -std=c99
 // #cgo CFLAGS: -O2
+// #cgo LDFLAGS: -lm
 //
 // #cgo amd64 CFLAGS: -march=x86-64 -mtune=generic
 // #cgo amd64 CFLAGS: -fopenmp
@@ -31,6 +32,9 @@ import (
 	"math"
 )
 
+// hasOpenMP is true if the C implementation was compiled with OpenMP, i.e. searches on all cores.
+var hasOpenMP = C.HasOpenMPC() != 0
+
 // SearchRGBAC searches for the position of an (RGBA) patch in an (RGBA) image,
 // using cosine similarity.
 // Implemented in Cgo.
@@ -70,3 +74,93 @@ func SearchRGBAC(img, pat *image.RGBA) (int, int, float64) {
 
 	return int(maxX), int(maxY), cos
 }
+
+// ScoreRGBACosC computes the cosine similarity score for an (RGBA) patch
+// on an (RGBA) image at a given offset.
+// Implemented in Cgo.
+// Panics if the patch at offset is not fully contained in the image.
+// The alpha channel is ignored.
+func ScoreRGBACosC(img, pat *image.RGBA, offset image.Point) float64 {
+	checkOffset(img, pat, offset)
+
+	du, dv := pat.Bounds().Dx(), pat.Bounds().Dy()
+	is, ps := img.Stride, pat.Stride
+
+	cos2 := C.ScoreRGBACos2C(
+		C.int(du), C.int(dv), C.int(is), C.int(ps),
+		(*C.uint8_t)(&img.Pix[offset.Y*is+offset.X*four]),
+		(*C.uint8_t)(&pat.Pix[0]),
+	)
+
+	// This was left out above.
+	return math.Sqrt(float64(cos2))
+}
+
+// SearchRGBASubpixelC is like SearchRGBAC, but returns the position with sub-pixel precision,
+// interpolated from the scores of the neighboring positions.
+// Implemented in Cgo.
+func SearchRGBASubpixelC(img, pat *image.RGBA) (float64, float64, float64) {
+	x, y, cos := SearchRGBAC(img, pat)
+	fx, fy := refineSubpixel(img, pat, x, y, cos, bindScore(img, pat, ScoreRGBACosC))
+	return fx, fy, cos
+}
+
+// ScoreRGBAModeC computes the score (according to mode) for an (RGBA) patch
+// on an (RGBA) image at a given offset.
+// Implemented in Cgo.
+// Panics if the patch at offset is not fully contained in the image.
+// The alpha channel is ignored.
+func ScoreRGBAModeC(img, pat *image.RGBA, offset image.Point, mode Mode) float64 {
+	checkOffset(img, pat, offset)
+
+	return scorePlanesC(toPlanes(img, mode), toPlanes(pat, mode), offset.X, offset.Y, mode.zeroMean())
+}
+
+// scorePlanesC is the Cgo equivalent of scorePlanes.
+func scorePlanesC(img, pat planes, x, y int, zeroMean bool) float64 {
+	zm := 0
+	if zeroMean {
+		zm = 1
+	}
+
+	return float64(C.ScoreModeC(
+		C.int(pat.w), C.int(pat.h), C.int(img.stride), C.int(pat.stride),
+		C.int(img.bpp), C.int(img.nc), C.int(zm),
+		(*C.uint8_t)(&img.pix[y*img.stride+x*img.bpp]),
+		(*C.uint8_t)(&pat.pix[0]),
+	))
+}
+
+// SearchRGBAModeC searches for the position of an (RGBA) patch in an (RGBA) image,
+// using the score given by mode.
+// Implemented in Cgo.
+// Panics if the patch is larger than the image in any dimension.
+// The alpha channel is ignored.
+func SearchRGBAModeC(img, pat *image.RGBA, mode Mode) (int, int, float64) {
+	if pat.Bounds().Size().X > img.Bounds().Size().X ||
+		pat.Bounds().Size().Y > img.Bounds().Size().Y {
+		panic("patch too large")
+	}
+
+	imgP, patP := toPlanes(img, mode), toPlanes(pat, mode)
+	m, n := imgP.w-patP.w+1, imgP.h-patP.h+1
+	zeroMean := 0
+	if mode.zeroMean() {
+		zeroMean = 1
+	}
+
+	var maxX, maxY C.int
+	maxScore := C.float64(math.Inf(-1))
+
+	C.SearchModeC(
+		C.int(m), C.int(n), C.int(patP.w), C.int(patP.h), C.int(imgP.stride), C.int(patP.stride),
+		C.int(imgP.bpp), C.int(imgP.nc), C.int(zeroMean),
+		(*C.uint8_t)(&imgP.pix[0]),
+		(*C.uint8_t)(&patP.pix[0]),
+		(*C.int)(&maxX),
+		(*C.int)(&maxY),
+		(*C.float64)(&maxScore),
+	)
+
+	return int(maxX), int(maxY), float64(maxScore)
+}
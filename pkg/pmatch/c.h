@@ -10,3 +10,22 @@ void SearchRGBAC(const int m, const int n, const int du, const int dv,
                  const uint8_t* const imgPix, const uint8_t* const patPix,
                  /* return parameters */
                  int* maxX, int* maxY, float64* maxCos2);
+
+float64 ScoreRGBACos2C(const int du, const int dv, const int is, const int ps,
+                       /* pixels */
+                       const uint8_t* const imgPix, const uint8_t* const patPix);
+
+void SearchModeC(const int m, const int n, const int du, const int dv,
+                 const int is, const int ps, const int bpp, const int nc,
+                 const int zeroMean,
+                 /* pixels */
+                 const uint8_t* const imgPix, const uint8_t* const patPix,
+                 /* return parameters */
+                 int* maxX, int* maxY, float64* maxScore);
+
+float64 ScoreModeC(const int du, const int dv, const int is, const int ps,
+                   const int bpp, const int nc, const int zeroMean,
+                   /* pixels */
+                   const uint8_t* const imgPix, const uint8_t* const patPix);
+
+int HasOpenMPC(void);
@@ -0,0 +1,80 @@
+package pmatch
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// nextPow2 returns the smallest power of 2 >= n.
+func nextPow2(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// fft computes the discrete Fourier transform of x in place, using an iterative radix-2 algorithm.
+// len(x) must be a power of 2. If inverse is true, the inverse transform (including the 1/n factor) is computed.
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	if n&(n-1) != 0 {
+		panic("length must be a power of 2")
+	}
+
+	// Bit reversal permutation.
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := x[start+k]
+				b := x[start+k+size/2] * w
+				x[start+k] = a + b
+				x[start+k+size/2] = a - b
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		f := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= f
+		}
+	}
+}
+
+// fft2 computes the 2D discrete Fourier transform of x (row major, w x h) in place.
+// w and h must be powers of 2.
+func fft2(x []complex128, w, h int, inverse bool) {
+	for y := range h {
+		fft(x[y*w:(y+1)*w], inverse)
+	}
+
+	col := make([]complex128, h)
+	for u := range w {
+		for y := range h {
+			col[y] = x[y*w+u]
+		}
+		fft(col, inverse)
+		for y := range h {
+			x[y*w+u] = col[y]
+		}
+	}
+}
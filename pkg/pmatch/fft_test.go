@@ -0,0 +1,82 @@
+package pmatch
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_nextPow2(t *testing.T) {
+	assert.Equal(t, 1, nextPow2(0))
+	assert.Equal(t, 1, nextPow2(1))
+	assert.Equal(t, 2, nextPow2(2))
+	assert.Equal(t, 4, nextPow2(3))
+	assert.Equal(t, 256, nextPow2(256))
+	assert.Equal(t, 512, nextPow2(257))
+}
+
+func dftSlow(x []complex128) []complex128 {
+	n := len(x)
+	ret := make([]complex128, n)
+	for k := range n {
+		for j := range n {
+			ret[k] += x[j] * cmplx.Rect(1, -2*math.Pi*float64(j*k)/float64(n))
+		}
+	}
+	return ret
+}
+
+func Test_fft(t *testing.T) {
+	rng := rand.New(rand.NewSource(123))
+	for _, n := range []int{1, 2, 8, 64} {
+		x := make([]complex128, n)
+		for i := range x {
+			x[i] = complex(rng.Float64(), rng.Float64())
+		}
+		orig := append([]complex128(nil), x...)
+
+		fft(x, false)
+		for i, v := range dftSlow(orig) {
+			assert.InDelta(t, real(v), real(x[i]), 1e-9)
+			assert.InDelta(t, imag(v), imag(x[i]), 1e-9)
+		}
+
+		fft(x, true)
+		for i, v := range orig {
+			assert.InDelta(t, real(v), real(x[i]), 1e-9)
+			assert.InDelta(t, imag(v), imag(x[i]), 1e-9)
+		}
+	}
+
+	assert.Panics(t, func() {
+		fft(make([]complex128, 6), false)
+	})
+}
+
+func Test_fft2(t *testing.T) {
+	const w, h = 8, 4
+	x := make([]complex128, w*h)
+	// Single impulse at (3, 1) transforms to a pure phase ramp.
+	x[1*w+3] = 1
+
+	fft2(x, w, h, false)
+	for v := range h {
+		for u := range w {
+			expected := cmplx.Rect(1, -2*math.Pi*(float64(u*3)/w+float64(v*1)/h))
+			assert.InDelta(t, real(expected), real(x[v*w+u]), 1e-12)
+			assert.InDelta(t, imag(expected), imag(x[v*w+u]), 1e-12)
+		}
+	}
+
+	fft2(x, w, h, true)
+	for i, v := range x {
+		if i == 1*w+3 {
+			assert.InDelta(t, 1, real(v), 1e-12)
+		} else {
+			assert.InDelta(t, 0, cmplx.Abs(v), 1e-12)
+		}
+	}
+}
@@ -1,12 +1,49 @@
 package pmatch
 
 import (
+	"fmt"
 	"image"
 )
 
 // Instance is the common interface for SearchRGBA implementations.
 type Instance interface {
 	SearchRGBA(img, pat *image.RGBA) (int, int, float64)
+	// SearchRGBASubpixel is like SearchRGBA, but returns the position with sub-pixel precision.
+	SearchRGBASubpixel(img, pat *image.RGBA) (float64, float64, float64)
 	Kind() string
 	Destroy()
 }
+
+// Matcher names, to be passed to NewMatcher().
+const (
+	// MatcherDefault is the fastest exhaustive cosine similarity search available, see NewInstance().
+	MatcherDefault = "default"
+	// MatcherPyramid uses SearchRGBAPyramid().
+	MatcherPyramid = "pyramid"
+	// MatcherPhaseCorr uses SearchRGBAPhaseCorr().
+	MatcherPhaseCorr = "phasecorr"
+	// MatcherParallel uses Parallel with one worker per CPU.
+	// The default implementation already uses all cores, either via OpenMP or by falling back to Parallel.
+	MatcherParallel = "parallel"
+)
+
+// NewMatcher instantiates an Instance by matcher name (one of the Matcher* constants), using the given score mode.
+// An empty name is the same as MatcherDefault.
+func NewMatcher(name string, mode Mode) (Instance, error) {
+	if _, ok := modeNames[mode]; !ok {
+		return nil, fmt.Errorf("invalid mode %s", mode)
+	}
+
+	switch name {
+	case "", MatcherDefault:
+		return NewInstanceMode(mode), nil
+	case MatcherPyramid:
+		return NewPyramidInstance(PyramidDefaultLevels, mode), nil
+	case MatcherPhaseCorr:
+		return NewPhaseCorrInstance(mode), nil
+	case MatcherParallel:
+		return NewParallelInstance(0, mode), nil
+	default:
+		return nil, fmt.Errorf("unknown matcher '%s'", name)
+	}
+}
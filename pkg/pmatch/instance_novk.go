@@ -2,10 +2,15 @@
 
 package pmatch
 
-import "image"
+import (
+	"image"
+	"runtime"
+)
 
 // C implements Instance.
-type C struct{}
+type C struct {
+	Mode Mode
+}
 
 // Destroy implements Instance.
 func (p *C) Destroy() {}
@@ -17,13 +22,30 @@ func (p *C) Kind() string {
 
 // SearchRGBA implements Instance.
 func (p *C) SearchRGBA(img *image.RGBA, pat *image.RGBA) (int, int, float64) {
-	return SearchRGBAC(img, pat)
+	return p.Mode.searchC()(img, pat)
+}
+
+// SearchRGBASubpixel implements Instance.
+func (p *C) SearchRGBASubpixel(img *image.RGBA, pat *image.RGBA) (float64, float64, float64) {
+	x, y, score := p.SearchRGBA(img, pat)
+	fx, fy := refineSubpixel(img, pat, x, y, score, p.Mode.scorerC(img, pat))
+	return fx, fy, score
 }
 
 // Compile time interface check.
 var _ Instance = (*C)(nil)
 
-// NewInstance instantiates C.
+// NewInstance instantiates the default Instance, using ModeCos.
 func NewInstance() Instance {
-	return &C{}
+	return NewInstanceMode(ModeCos)
+}
+
+// NewInstanceMode instantiates C.
+// If C was compiled without OpenMP (e.g. on 32 bit ARM), Parallel is used instead on multi-core machines.
+// Destroy() must be called when done.
+func NewInstanceMode(mode Mode) Instance {
+	if !hasOpenMP && runtime.NumCPU() > 1 {
+		return NewParallelInstance(runtime.NumCPU(), mode)
+	}
+	return &C{Mode: mode}
 }
@@ -0,0 +1,22 @@
+//go:build !vk
+
+package pmatch
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewInstanceMode_Kind(t *testing.T) {
+	inst := NewInstanceMode(ModeZNCC)
+	defer inst.Destroy()
+
+	if !hasOpenMP && runtime.NumCPU() > 1 {
+		assert.Equal(t, "Parallel", inst.Kind())
+	} else {
+		assert.Equal(t, "C", inst.Kind())
+	}
+	t.Log("OpenMP:", hasOpenMP, "CPUs:", runtime.NumCPU())
+}
@@ -34,3 +34,25 @@ func Test_NewInstance(t *testing.T) {
 
 	t.Log("Instance Kind:", inst.Kind())
 }
+
+func Test_NewMatcher(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	for _, name := range []string{"", MatcherDefault, MatcherPyramid, MatcherPhaseCorr, MatcherParallel} {
+		inst, err := NewMatcher(name, ModeCos)
+		require.NoError(t, err)
+
+		x, y, score := inst.SearchRGBA(img, pat.(*image.RGBA))
+		assert.InDelta(t, 1., score, delta, inst.Kind())
+		assert.Equal(t, x0, x, inst.Kind())
+		assert.Equal(t, y0, y, inst.Kind())
+		inst.Destroy()
+	}
+
+	_, err = NewMatcher("unknown", ModeCos)
+	assert.Error(t, err)
+	_, err = NewMatcher(MatcherDefault, Mode(99))
+	assert.Error(t, err)
+}
@@ -15,6 +15,8 @@ type params struct {
 
 type PMatchVk struct {
 	pool map[params]*SearchVk
+	// Only ModeCos is implemented in Vulkan, other modes are delegated to the C implementation.
+	mode Mode
 }
 
 // Destroy implements Instance.
@@ -31,6 +33,10 @@ func (p *PMatchVk) Kind() string {
 
 // SearchRGBA implements Instance.
 func (p *PMatchVk) SearchRGBA(img *image.RGBA, pat *image.RGBA) (int, int, float64) {
+	if p.mode != ModeCos {
+		return p.mode.searchC()(img, pat)
+	}
+
 	params := params{img.Bounds(), pat.Bounds(), img.Stride, pat.Stride}
 
 	inst, ok := p.pool[params]
@@ -57,9 +63,23 @@ func (p *PMatchVk) SearchRGBA(img *image.RGBA, pat *image.RGBA) (int, int, float
 	return maxX, maxY, maxCos
 }
 
+// SearchRGBASubpixel implements Instance.
+// The integer search runs on the GPU, the refinement on the CPU.
+func (p *PMatchVk) SearchRGBASubpixel(img *image.RGBA, pat *image.RGBA) (float64, float64, float64) {
+	x, y, cos := p.SearchRGBA(img, pat)
+	fx, fy := refineSubpixel(img, pat, x, y, cos, p.mode.scorerC(img, pat))
+	return fx, fy, cos
+}
+
 // Compile time interface check.
 var _ Instance = (*PMatchVk)(nil)
 
+// NewInstance instantiates PMatchVk, using ModeCos.
 func NewInstance() Instance {
-	return &PMatchVk{pool: map[params]*SearchVk{}}
+	return NewInstanceMode(ModeCos)
+}
+
+// NewInstanceMode instantiates PMatchVk.
+func NewInstanceMode(mode Mode) Instance {
+	return &PMatchVk{pool: map[params]*SearchVk{}, mode: mode}
 }
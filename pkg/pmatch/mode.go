@@ -0,0 +1,214 @@
+package pmatch
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Mode selects the similarity score used for matching.
+type Mode int
+
+const (
+	// ModeCos is the uncentered cosine similarity over RGB. This is the default.
+	ModeCos Mode = iota
+	// ModeZNCC is the zero-mean normalized cross-correlation over RGB.
+	// It is insensitive to uniform brightness shifts.
+	ModeZNCC
+	// ModeLumaCos is the uncentered cosine similarity over luminance only.
+	ModeLumaCos
+	// ModeLumaZNCC is the zero-mean normalized cross-correlation over luminance only.
+	ModeLumaZNCC
+)
+
+var modeNames = map[Mode]string{
+	ModeCos:      "cos",
+	ModeZNCC:     "zncc",
+	ModeLumaCos:  "luma-cos",
+	ModeLumaZNCC: "luma-zncc",
+}
+
+// String implements fmt.Stringer.
+func (m Mode) String() string {
+	name, ok := modeNames[m]
+	if !ok {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return name
+}
+
+// ParseMode parses a mode from its name (as returned by String()).
+func ParseMode(name string) (Mode, error) {
+	for m, n := range modeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mode '%s'", name)
+}
+
+func (m Mode) luma() bool {
+	return m == ModeLumaCos || m == ModeLumaZNCC
+}
+
+func (m Mode) zeroMean() bool {
+	return m == ModeZNCC || m == ModeLumaZNCC
+}
+
+// searchC returns the C search implementation for this mode.
+func (m Mode) searchC() func(img, pat *image.RGBA) (int, int, float64) {
+	if m == ModeCos {
+		return SearchRGBAC
+	}
+	return func(img, pat *image.RGBA) (int, int, float64) {
+		return SearchRGBAModeC(img, pat, m)
+	}
+}
+
+// scorerC returns the C score implementation for this mode, bound to img and pat.
+// Image and patch are converted only once, so this should be used when scoring many offsets.
+func (m Mode) scorerC(img, pat *image.RGBA) scoreAtFn {
+	if m == ModeCos {
+		return bindScore(img, pat, ScoreRGBACosC)
+	}
+	imgP, patP := toPlanes(img, m), toPlanes(pat, m)
+	return func(offset image.Point) float64 {
+		checkOffset(img, pat, offset)
+		return scorePlanesC(imgP, patP, offset.X, offset.Y, m.zeroMean())
+	}
+}
+
+// scorerGo returns the Go score implementation for this mode, bound to img and pat.
+// Image and patch are converted only once, so this should be used when scoring many offsets.
+func (m Mode) scorerGo(img, pat *image.RGBA) scoreAtFn {
+	if m == ModeCos {
+		return bindScore(img, pat, ScoreRGBACos)
+	}
+	imgP, patP := toPlanes(img, m), toPlanes(pat, m)
+	return func(offset image.Point) float64 {
+		checkOffset(img, pat, offset)
+		return scorePlanes(imgP, patP, offset.X, offset.Y, m.zeroMean())
+	}
+}
+
+// planes is the pixel data compared for a given mode: Either RGB out of RGBA, or luminance.
+type planes struct {
+	pix    []uint8
+	stride int
+	w, h   int
+	// Bytes per pixel, and number of channels compared per pixel.
+	bpp, nc int
+}
+
+// luma computes the luminance of an RGB pixel, same as color.GrayModel (which operates on 16 bit values).
+func luma(r, g, b uint8) uint8 {
+	r16, g16, b16 := uint32(r)*0x101, uint32(g)*0x101, uint32(b)*0x101
+	return uint8((19595*r16 + 38470*g16 + 7471*b16 + 1<<15) >> 24)
+}
+
+func toPlanes(img *image.RGBA, mode Mode) planes {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if !mode.luma() {
+		return planes{img.Pix, img.Stride, w, h, four, 3}
+	}
+
+	pix := make([]uint8, w*h)
+	for y := range h {
+		row := img.Pix[y*img.Stride:]
+		for x := range w {
+			pix[y*w+x] = luma(row[x*four], row[x*four+1], row[x*four+2])
+		}
+	}
+	return planes{pix, w, w, h, 1, 1}
+}
+
+// scoreFromSums computes the score from sums over all compared values:
+// dot = sum(i*p), sI = sum(i), sP = sum(p), sI2 = sum(i^2), sP2 = sum(p^2), and cnt values.
+func scoreFromSums(zeroMean bool, dot, sI, sP, sI2, sP2 uint64, cnt int) float64 {
+	if !zeroMean {
+		abs2 := float64(sI2) * float64(sP2)
+		if abs2 == 0 {
+			return 1
+		}
+		return float64(dot) / math.Sqrt(abs2)
+	}
+
+	n := float64(cnt)
+	num := float64(dot) - float64(sI)*float64(sP)/n
+	varI := float64(sI2) - float64(sI)*float64(sI)/n
+	varP := float64(sP2) - float64(sP)*float64(sP)/n
+	if varI <= 0 && varP <= 0 {
+		// Both flat.
+		return 1
+	}
+	if varI <= 0 || varP <= 0 {
+		return 0
+	}
+	return num / math.Sqrt(varI*varP)
+}
+
+// checkOffset panics if the patch at offset is not fully contained in the image.
+func checkOffset(img, pat *image.RGBA, offset image.Point) {
+	if offset.X < 0 || offset.Y < 0 ||
+		offset.X+pat.Bounds().Dx() > img.Bounds().Dx() ||
+		offset.Y+pat.Bounds().Dy() > img.Bounds().Dy() {
+		panic("patch not fully contained in image")
+	}
+}
+
+// scorePlanes computes the score of pat at offset (x, y) in img.
+func scorePlanes(img, pat planes, x, y int, zeroMean bool) float64 {
+	var dot, sI, sP, sI2, sP2 uint64
+	start := y*img.stride + x*img.bpp
+	for v := range pat.h {
+		rowI := img.pix[start+v*img.stride:]
+		rowP := pat.pix[v*pat.stride:]
+		for u := range pat.w {
+			for c := range pat.nc {
+				pxI := uint64(rowI[u*img.bpp+c])
+				pxP := uint64(rowP[u*pat.bpp+c])
+				dot += pxI * pxP
+				sI += pxI
+				sP += pxP
+				sI2 += pxI * pxI
+				sP2 += pxP * pxP
+			}
+		}
+	}
+	return scoreFromSums(zeroMean, dot, sI, sP, sI2, sP2, pat.w*pat.h*pat.nc)
+}
+
+// ScoreRGBAMode computes the score (according to mode) for an (RGBA) patch
+// on an (RGBA) image at a given offset.
+// Panics if the patch at offset is not fully contained in the image.
+// The alpha channel is ignored.
+func ScoreRGBAMode(img, pat *image.RGBA, offset image.Point, mode Mode) float64 {
+	checkOffset(img, pat, offset)
+	return scorePlanes(toPlanes(img, mode), toPlanes(pat, mode), offset.X, offset.Y, mode.zeroMean())
+}
+
+// SearchRGBAMode searches for the position of an (RGBA) patch in an (RGBA) image,
+// using the score given by mode.
+// Panics if the patch is larger than the image in any dimension.
+// The alpha channel is ignored.
+func SearchRGBAMode(img, pat *image.RGBA, mode Mode) (maxX, maxY int, maxScore float64) {
+	m, n := searchSize(img, pat)
+	return searchPlanesRows(toPlanes(img, mode), toPlanes(pat, mode), m, 0, n, mode.zeroMean())
+}
+
+// searchPlanesRows is the inner loop of SearchRGBAMode, for the patch positions in rows [y0, y1) and columns [0, m).
+func searchPlanesRows(img, pat planes, m, y0, y1 int, zeroMean bool) (maxX, maxY int, maxScore float64) {
+	maxScore = math.Inf(-1)
+	maxY = y0
+	for y := y0; y < y1; y++ {
+		for x := range m {
+			score := scorePlanes(img, pat, x, y, zeroMean)
+			if score > maxScore {
+				maxScore = score
+				maxX, maxY = x, y
+			}
+		}
+	}
+
+	return
+}
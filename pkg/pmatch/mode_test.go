@@ -0,0 +1,153 @@
+package pmatch
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+var allModes = []Mode{ModeCos, ModeZNCC, ModeLumaCos, ModeLumaZNCC}
+
+// modeTestData returns a search image around (x0, y0) and a patch out of it, at (x0, y0) of the test image.
+func modeTestData(t *testing.T) (*image.RGBA, *image.RGBA) {
+	full := imutil.ToRGBA(LoadTestImg())
+	img, err := imutil.Sub(full, image.Rect(x0-10, y0-8, x0+w+10, y0+h+8))
+	require.NoError(t, err)
+	pat, err := imutil.Sub(full, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+	return imutil.ToRGBA(img), imutil.ToRGBA(pat)
+}
+
+// brighten returns a copy of img with val added to all channels.
+// Must not saturate.
+func brighten(img *image.RGBA, val uint8) *image.RGBA {
+	ret := imutil.ToRGBA(img)
+	for i := range ret.Pix {
+		if i%4 != 3 {
+			ret.Pix[i] += val
+		}
+	}
+	return ret
+}
+
+func Test_ParseMode(t *testing.T) {
+	for _, m := range allModes {
+		parsed, err := ParseMode(m.String())
+		require.NoError(t, err)
+		assert.Equal(t, m, parsed)
+	}
+
+	_, err := ParseMode("unknown")
+	assert.Error(t, err)
+	assert.Equal(t, "Mode(99)", Mode(99).String())
+}
+
+func Test_ScoreRGBAMode(t *testing.T) {
+	img, pat := modeTestData(t)
+
+	for _, m := range allModes {
+		for _, off := range []image.Point{{10, 8}, {0, 0}, {3, 11}, {20, 16}} {
+			slow := ScoreRGBAModeSlow(img, pat, off, m)
+			assert.InDelta(t, slow, ScoreRGBAMode(img, pat, off, m), 1e-9, m)
+			assert.InDelta(t, slow, ScoreRGBAModeC(img, pat, off, m), 1e-9, m)
+		}
+	}
+
+	// The generic implementation is equivalent to the dedicated cosine similarity one.
+	off := image.Pt(3, 11)
+	assert.InDelta(t, ScoreRGBACos(img, pat, off), ScoreRGBAMode(img, pat, off, ModeCos), 1e-9)
+
+	assert.Panics(t, func() {
+		ScoreRGBAModeC(img, pat, image.Pt(21, 0), ModeZNCC)
+	})
+}
+
+func Test_Mode_scorer(t *testing.T) {
+	img, pat := modeTestData(t)
+
+	for _, m := range allModes {
+		scoreC, scoreGo := m.scorerC(img, pat), m.scorerGo(img, pat)
+		for _, off := range []image.Point{{10, 8}, {0, 0}, {3, 11}, {20, 16}} {
+			expected := ScoreRGBAMode(img, pat, off, m)
+			assert.InDelta(t, expected, scoreC(off), 1e-9, m)
+			assert.InDelta(t, expected, scoreGo(off), 1e-9, m)
+		}
+
+		assert.Panics(t, func() { scoreC(image.Pt(21, 0)) }, m)
+		assert.Panics(t, func() { scoreGo(image.Pt(0, 17)) }, m)
+	}
+}
+
+func Test_ScoreRGBAMode_Flat(t *testing.T) {
+	flat := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	_, pat := modeTestData(t)
+	pat = imutil.ToRGBA(pat.SubImage(image.Rect(0, 0, 10, 10)).(*image.RGBA))
+
+	for _, m := range []Mode{ModeZNCC, ModeLumaZNCC} {
+		assert.Equal(t, 1., ScoreRGBAMode(flat, flat, image.Pt(0, 0), m))
+		assert.Equal(t, 0., ScoreRGBAMode(flat, pat, image.Pt(0, 0), m))
+		assert.Equal(t, 1., ScoreRGBAModeC(flat, flat, image.Pt(0, 0), m))
+		assert.Equal(t, 0., ScoreRGBAModeC(flat, pat, image.Pt(0, 0), m))
+	}
+}
+
+func Test_SearchRGBAMode(t *testing.T) {
+	img, pat := modeTestData(t)
+
+	for _, m := range allModes {
+		xS, yS, scoreS := SearchRGBAModeSlow(img, pat, m)
+		assert.Equal(t, 10, xS, m)
+		assert.Equal(t, 8, yS, m)
+		assert.InDelta(t, 1., scoreS, 1e-9, m)
+
+		x, y, score := SearchRGBAMode(img, pat, m)
+		assert.Equal(t, xS, x, m)
+		assert.Equal(t, yS, y, m)
+		assert.InDelta(t, scoreS, score, 1e-9, m)
+
+		x, y, score = SearchRGBAModeC(img, pat, m)
+		assert.Equal(t, xS, x, m)
+		assert.Equal(t, yS, y, m)
+		assert.InDelta(t, scoreS, score, 1e-9, m)
+	}
+}
+
+func Test_SearchRGBAMode_Brightness(t *testing.T) {
+	img, pat := modeTestData(t)
+	pat = brighten(pat, 20)
+
+	for _, m := range []Mode{ModeZNCC, ModeLumaZNCC} {
+		x, y, score := SearchRGBAModeC(img, pat, m)
+		assert.Equal(t, 10, x, m)
+		assert.Equal(t, 8, y, m)
+		// Not exactly 1 because of luma rounding.
+		assert.InDelta(t, 1., score, 1e-3, m)
+	}
+
+	// Cosine similarity is affected by the brightness shift.
+	_, _, score := SearchRGBAModeC(img, pat, ModeCos)
+	assert.Less(t, score, 0.999)
+}
+
+func Test_NewMatcher_Modes(t *testing.T) {
+	img, pat := modeTestData(t)
+	pat = brighten(pat, 20)
+
+	for _, name := range []string{MatcherDefault, MatcherPyramid, MatcherPhaseCorr, MatcherParallel} {
+		inst, err := NewMatcher(name, ModeZNCC)
+		require.NoError(t, err)
+
+		x, y, score := inst.SearchRGBA(img, pat)
+		assert.Equal(t, 10, x, name)
+		assert.Equal(t, 8, y, name)
+		assert.InDelta(t, 1., score, 1e-6, name)
+
+		fx, fy, _ := inst.SearchRGBASubpixel(img, pat)
+		assert.InDelta(t, 10, fx, 0.1, name)
+		assert.InDelta(t, 8, fy, 0.1, name)
+		inst.Destroy()
+	}
+}
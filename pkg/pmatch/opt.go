@@ -13,6 +13,18 @@ const four = 4
 // Panics (due to out of bounds errors) if the patch is larger than the image in any dimension.
 // The alpha channel is ignored.
 func SearchRGBA(img, pat *image.RGBA) (maxX, maxY int, maxCos float64) {
+	m, n := searchSize(img, pat)
+	maxX, maxY, maxCos2 := searchRGBARows(img, pat, m, 0, n)
+
+	// This was left out in searchRGBARows().
+	maxCos = math.Sqrt(maxCos2)
+
+	return
+}
+
+// searchSize returns the number of patch positions in x and y direction.
+// Panics if the patch is larger than the image in any dimension.
+func searchSize(img, pat *image.RGBA) (m, n int) {
 	if pat.Bounds().Size().X > img.Bounds().Size().X ||
 		pat.Bounds().Size().Y > img.Bounds().Size().Y {
 		panic("patch too large")
@@ -24,13 +36,18 @@ func SearchRGBA(img, pat *image.RGBA) (maxX, maxY int, maxCos float64) {
 		Max: img.Bounds().Max.Sub(pat.Bounds().Size()).Add(image.Pt(1, 1)),
 	}
 
-	m, n := searchRect.Dx(), searchRect.Dy()
+	return searchRect.Dx(), searchRect.Dy()
+}
+
+// searchRGBARows is the inner loop of SearchRGBA, for the patch positions in rows [y0, y1) and columns [0, m).
+// Returns the squared cosine similarity.
+func searchRGBARows(img, pat *image.RGBA, m, y0, y1 int) (maxX, maxY int, maxCos2 float64) {
 	du, dv := pat.Bounds().Dx(), pat.Bounds().Dy()
 
 	is, ps := img.Stride, pat.Stride
 
-	var maxCos2 float64
-	for y := range n {
+	maxY = y0
+	for y := y0; y < y1; y++ {
 		for x := range m {
 
 			imgPatStartIx := y*is + x*four
@@ -69,8 +86,49 @@ func SearchRGBA(img, pat *image.RGBA) (maxX, maxY int, maxCos float64) {
 		}
 	}
 
-	// This was left out above.
-	maxCos = math.Sqrt(maxCos2)
-
 	return
 }
+
+// ScoreRGBACos computes the cosine similarity score for an (RGBA) patch
+// on an (RGBA) image at a given offset.
+// Slightly optimized implementation.
+// Panics (due to out of bounds errors) if the patch at offset is not fully contained in the image.
+// The alpha channel is ignored.
+func ScoreRGBACos(img, pat *image.RGBA, offset image.Point) float64 {
+	checkOffset(img, pat, offset)
+
+	du, dv := pat.Bounds().Dx(), pat.Bounds().Dy()
+	is, ps := img.Stride, pat.Stride
+	imgPatStartIx := offset.Y*is + offset.X*four
+
+	var dot, absI2, absP2 uint64
+	for v := range dv {
+		pxIi := v * is
+		pxPi := v * ps
+
+		for u := range du {
+			for rgb := range 3 {
+				pxI := img.Pix[imgPatStartIx+pxIi+u*four+rgb]
+				pxP := pat.Pix[pxPi+u*four+rgb]
+
+				dot += uint64(pxI) * uint64(pxP)
+				absI2 += uint64(pxI) * uint64(pxI)
+				absP2 += uint64(pxP) * uint64(pxP)
+			}
+		}
+	}
+
+	abs2 := float64(absI2) * float64(absP2)
+	if abs2 == 0 {
+		return 1
+	}
+	return float64(dot) / math.Sqrt(abs2)
+}
+
+// SearchRGBASubpixel is like SearchRGBA, but returns the position with sub-pixel precision,
+// interpolated from the scores of the neighboring positions.
+func SearchRGBASubpixel(img, pat *image.RGBA) (maxX, maxY, maxCos float64) {
+	x, y, cos := SearchRGBA(img, pat)
+	maxX, maxY = refineSubpixel(img, pat, x, y, cos, bindScore(img, pat, ScoreRGBACos))
+	return maxX, maxY, cos
+}
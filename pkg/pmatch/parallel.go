@@ -0,0 +1,118 @@
+package pmatch
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Parallel implements Instance.
+// The search positions are split into chunks of rows, which are processed by a pool of worker goroutines.
+// Results are identical to SearchRGBA() (or SearchRGBAMode() for other modes).
+type Parallel struct {
+	Workers int
+	Mode    Mode
+
+	jobs    chan func()
+	destroy sync.Once
+}
+
+// Destroy implements Instance.
+// Can be called multiple times.
+func (p *Parallel) Destroy() {
+	p.destroy.Do(func() {
+		close(p.jobs)
+	})
+}
+
+// Kind implements Instance.
+func (p *Parallel) Kind() string {
+	return "Parallel"
+}
+
+// searchResult is the best match within one chunk of rows.
+type searchResult struct {
+	x, y  int
+	score float64
+}
+
+// search runs rows over chunks of [0, n) on the worker pool, and merges the results.
+// Ties are resolved in favor of the first chunk, so the result is the same as when searching all rows at once.
+func (p *Parallel) search(n int, rows func(y0, y1 int) searchResult) searchResult {
+	chunks := min(p.Workers, n)
+	results := make([]searchResult, chunks)
+
+	var wg sync.WaitGroup
+	wg.Add(chunks)
+	for i := range chunks {
+		y0, y1 := i*n/chunks, (i+1)*n/chunks
+		p.jobs <- func() {
+			defer wg.Done()
+			results[i] = rows(y0, y1)
+		}
+	}
+	wg.Wait()
+
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.score > best.score {
+			best = r
+		}
+	}
+	return best
+}
+
+// SearchRGBA implements Instance.
+func (p *Parallel) SearchRGBA(img *image.RGBA, pat *image.RGBA) (int, int, float64) {
+	m, n := searchSize(img, pat)
+
+	if p.Mode == ModeCos {
+		r := p.search(n, func(y0, y1 int) searchResult {
+			x, y, cos2 := searchRGBARows(img, pat, m, y0, y1)
+			return searchResult{x, y, cos2}
+		})
+		return r.x, r.y, math.Sqrt(r.score)
+	}
+
+	imgP, patP := toPlanes(img, p.Mode), toPlanes(pat, p.Mode)
+	r := p.search(n, func(y0, y1 int) searchResult {
+		x, y, score := searchPlanesRows(imgP, patP, m, y0, y1, p.Mode.zeroMean())
+		return searchResult{x, y, score}
+	})
+	return r.x, r.y, r.score
+}
+
+// SearchRGBASubpixel implements Instance.
+func (p *Parallel) SearchRGBASubpixel(img *image.RGBA, pat *image.RGBA) (float64, float64, float64) {
+	x, y, score := p.SearchRGBA(img, pat)
+	fx, fy := refineSubpixel(img, pat, x, y, score, p.Mode.scorerGo(img, pat))
+	return fx, fy, score
+}
+
+// Compile time interface check.
+var _ Instance = (*Parallel)(nil)
+
+// NewParallelInstance instantiates Parallel, and starts its worker pool.
+// If workers <= 0, runtime.NumCPU() is used.
+// Destroy() must be called to stop the workers.
+func NewParallelInstance(workers int, mode Mode) Instance {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	p := &Parallel{
+		Workers: workers,
+		Mode:    mode,
+		jobs:    make(chan func()),
+	}
+	for range workers {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+
+	return p
+}
@@ -0,0 +1,89 @@
+package pmatch
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func Test_Parallel(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+	patCopy := imutil.ToRGBA(pat.(*image.RGBA))
+
+	xE, yE, scoreE := SearchRGBA(img, patCopy)
+	for _, workers := range []int{1, 2, 3, 7, 1000} {
+		inst := NewParallelInstance(workers, ModeCos)
+
+		x, y, score := inst.SearchRGBA(img, patCopy)
+		assert.Equal(t, xE, x, workers)
+		assert.Equal(t, yE, y, workers)
+		assert.Equal(t, scoreE, score, workers)
+
+		fxE, fyE, _ := SearchRGBASubpixel(img, patCopy)
+		fx, fy, _ := inst.SearchRGBASubpixel(img, patCopy)
+		assert.Equal(t, fxE, fx, workers)
+		assert.Equal(t, fyE, fy, workers)
+
+		inst.Destroy()
+	}
+
+	inst := NewParallelInstance(0, ModeCos)
+	defer inst.Destroy()
+	assert.Equal(t, "Parallel", inst.Kind())
+	// Destroying twice is fine.
+	inst.Destroy()
+}
+
+func Test_Parallel_Modes(t *testing.T) {
+	img, pat := modeTestData(t)
+	pat = brighten(pat, 7)
+
+	for _, m := range allModes {
+		xE, yE, scoreE := SearchRGBAMode(img, pat, m)
+
+		inst := NewParallelInstance(3, m)
+		x, y, score := inst.SearchRGBA(img, pat)
+		assert.Equal(t, xE, x, m)
+		assert.Equal(t, yE, y, m)
+		assert.InDelta(t, scoreE, score, 1e-15, m)
+		inst.Destroy()
+	}
+}
+
+func Test_Parallel_Ties(t *testing.T) {
+	// All positions score the same, the first one has to win.
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	pat := image.NewRGBA(image.Rect(0, 0, 5, 5))
+
+	for _, m := range allModes {
+		inst := NewParallelInstance(4, m)
+		x, y, score := inst.SearchRGBA(img, pat)
+		assert.Equal(t, 0, x, m)
+		assert.Equal(t, 0, y, m)
+		assert.Equal(t, 1., score, m)
+		inst.Destroy()
+	}
+}
+
+func Benchmark_Parallel(b *testing.B) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	if err != nil {
+		b.Error(err)
+	}
+
+	// Make sure pattern lives in a different memory region.
+	pat = imutil.ToRGBA(pat.(*image.RGBA))
+
+	inst := NewParallelInstance(0, ModeCos)
+	defer inst.Destroy()
+
+	for i := 0; i < b.N; i++ {
+		inst.SearchRGBA(img, pat.(*image.RGBA))
+	}
+}
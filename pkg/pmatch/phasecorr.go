@@ -0,0 +1,140 @@
+package pmatch
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// Added to magnitudes before normalizing the cross power spectrum, to avoid division by zero.
+	phaseCorrEpsilon = 1e-9
+	// Positions within this distance [px] of the highest peak belong to it, and are ignored when looking for the second highest.
+	phaseCorrPeakRadius = 2
+)
+
+// lumaZeroMean writes the luma of img into dst (row major, with stride dstW), minus its mean.
+func lumaZeroMean(img *image.RGBA, dst []complex128, dstW int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	var sum float64
+	for y := range h {
+		row := img.Pix[y*img.Stride:]
+		for x := range w {
+			l := 0.299*float64(row[x*four]) + 0.587*float64(row[x*four+1]) + 0.114*float64(row[x*four+2])
+			dst[y*dstW+x] = complex(l, 0)
+			sum += l
+		}
+	}
+
+	mean := complex(sum/float64(w*h), 0)
+	for y := range h {
+		for x := range w {
+			dst[y*dstW+x] -= mean
+		}
+	}
+}
+
+// SearchRGBAPhaseCorr searches for the position of an (RGBA) patch in an (RGBA) image,
+// using phase correlation on luma, computed via FFT.
+// As only the phase is used, this is insensitive to brightness and contrast differences between img and pat.
+//
+// The returned confidence is 1 - second/peak, where peak is the highest value of the (normalized) correlation
+// surface and second the highest value outside its immediate surroundings. It is in [0, 1], but unlike
+// cosine similarity it measures how distinct the match is, not how similar img and pat are at that position:
+// Good matches are typically in 0.7-1, and noise still reaches 0.1-0.3 (where cosine similarity often is > 0.5).
+// The absolute peak is not used, as it shrinks with the area of pat relative to img.
+// Panics if the patch is larger than the image in any dimension.
+func SearchRGBAPhaseCorr(img, pat *image.RGBA) (maxX, maxY int, conf float64) {
+	if pat.Bounds().Size().X > img.Bounds().Size().X ||
+		pat.Bounds().Size().Y > img.Bounds().Size().Y {
+		panic("patch too large")
+	}
+
+	m := img.Bounds().Dx() - pat.Bounds().Dx() + 1
+	n := img.Bounds().Dy() - pat.Bounds().Dy() + 1
+	w, h := nextPow2(img.Bounds().Dx()), nextPow2(img.Bounds().Dy())
+
+	a := make([]complex128, w*h)
+	b := make([]complex128, w*h)
+	lumaZeroMean(img, a, w)
+	lumaZeroMean(pat, b, w)
+	fft2(a, w, h, false)
+	fft2(b, w, h, false)
+
+	// Normalized cross power spectrum.
+	for i := range a {
+		c := a[i] * cmplx.Conj(b[i])
+		a[i] = c / complex(cmplx.Abs(c)+phaseCorrEpsilon, 0)
+	}
+	fft2(a, w, h, true)
+
+	// Only consider positions where the patch is fully contained in the image.
+	maxCorr := math.Inf(-1)
+	for y := range n {
+		for x := range m {
+			corr := real(a[y*w+x])
+			if corr > maxCorr {
+				maxCorr = corr
+				maxX, maxY = x, y
+			}
+		}
+	}
+
+	secondCorr := math.Inf(-1)
+	for y := range n {
+		for x := range m {
+			if max(x-maxX, maxX-x) <= phaseCorrPeakRadius && max(y-maxY, maxY-y) <= phaseCorrPeakRadius {
+				continue
+			}
+			secondCorr = max(secondCorr, real(a[y*w+x]))
+		}
+	}
+
+	if maxCorr <= 0 {
+		return maxX, maxY, 0
+	}
+	if math.IsInf(secondCorr, -1) {
+		// No other position.
+		return maxX, maxY, 1
+	}
+	return maxX, maxY, min(1, max(0, 1-secondCorr/maxCorr))
+}
+
+// PhaseCorr implements Instance, using SearchRGBAPhaseCorr().
+// Mode only determines the returned score, not the search.
+// The score is that of Mode at the found position, not the peak confidence of SearchRGBAPhaseCorr(),
+// so that it can be used with the same thresholds as the other implementations.
+type PhaseCorr struct {
+	Mode Mode
+}
+
+// Destroy implements Instance.
+func (p *PhaseCorr) Destroy() {}
+
+// Kind implements Instance.
+func (p *PhaseCorr) Kind() string {
+	return "PhaseCorr"
+}
+
+// SearchRGBA implements Instance.
+func (p *PhaseCorr) SearchRGBA(img *image.RGBA, pat *image.RGBA) (int, int, float64) {
+	x, y, _ := SearchRGBAPhaseCorr(img, pat)
+	return x, y, p.Mode.scorerGo(img, pat)(image.Pt(x, y))
+}
+
+// SearchRGBASubpixel implements Instance.
+// Refinement uses the score surface, like the other implementations.
+func (p *PhaseCorr) SearchRGBASubpixel(img *image.RGBA, pat *image.RGBA) (float64, float64, float64) {
+	x, y, score := p.SearchRGBA(img, pat)
+	fx, fy := refineSubpixel(img, pat, x, y, score, p.Mode.scorerGo(img, pat))
+	return fx, fy, score
+}
+
+// Compile time interface check.
+var _ Instance = (*PhaseCorr)(nil)
+
+// NewPhaseCorrInstance instantiates PhaseCorr.
+func NewPhaseCorrInstance(mode Mode) Instance {
+	return &PhaseCorr{Mode: mode}
+}
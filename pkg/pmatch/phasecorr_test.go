@@ -0,0 +1,103 @@
+package pmatch
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func Test_SearchRGBAPhaseCorr(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	x, y, conf := SearchRGBAPhaseCorr(img, pat.(*image.RGBA))
+	assert.Greater(t, conf, 0.7)
+	assert.LessOrEqual(t, conf, 1.)
+	assert.Equal(t, x0, x)
+	assert.Equal(t, y0, y)
+
+	// Also resets pat bounds origin to (0,0).
+	patCopy := imutil.ToRGBA(pat.(*image.RGBA))
+
+	x, y, conf2 := SearchRGBAPhaseCorr(img, patCopy)
+	assert.InDelta(t, conf, conf2, 1e-9)
+	assert.Equal(t, x0, x)
+	assert.Equal(t, y0, y)
+
+	// No match.
+	_, _, conf = SearchRGBAPhaseCorr(img, imutil.RandRGBA(1, w, h))
+	assert.Less(t, conf, 0.3)
+}
+
+func Test_SearchRGBAPhaseCorr_Exposure(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	// Darker and less contrast.
+	patCopy := imutil.ToRGBA(pat.(*image.RGBA))
+	for i := range patCopy.Pix {
+		if i%4 != 3 {
+			patCopy.Pix[i] = patCopy.Pix[i]/2 + 40
+		}
+	}
+
+	x, y, conf := SearchRGBAPhaseCorr(img, patCopy)
+	assert.Equal(t, x0, x)
+	assert.Equal(t, y0, y)
+	assert.Greater(t, conf, 0.7)
+
+	// The instance reports the cosine similarity instead.
+	_, _, score := NewPhaseCorrInstance(ModeCos).SearchRGBA(img, patCopy)
+	assert.InDelta(t, ScoreRGBACosSlow(img, patCopy, image.Pt(x0, y0)), score, 1e-12)
+}
+
+func Test_SearchRGBAPhaseCorr_Wide(t *testing.T) {
+	img, pat := wideSearchData()
+
+	x, y, conf := SearchRGBAPhaseCorr(img, pat)
+	assert.Greater(t, conf, 0.9)
+	assert.Equal(t, 60, x)
+	assert.Equal(t, 0, y)
+}
+
+func Test_NewPhaseCorrInstance(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	inst := NewPhaseCorrInstance(ModeCos)
+	defer inst.Destroy()
+
+	fx, fy, score := inst.SearchRGBASubpixel(img, pat.(*image.RGBA))
+	assert.InDelta(t, 1., score, delta)
+	assert.InDelta(t, x0, fx, 0.05)
+	assert.InDelta(t, y0, fy, 0.05)
+	assert.Equal(t, "PhaseCorr", inst.Kind())
+}
+
+func Benchmark_SearchRGBAPhaseCorr(b *testing.B) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	if err != nil {
+		b.Error(err)
+	}
+
+	// Make sure pattern lives in a different memory region.
+	pat = imutil.ToRGBA(pat.(*image.RGBA))
+
+	for i := 0; i < b.N; i++ {
+		SearchRGBAPhaseCorr(img, pat.(*image.RGBA))
+	}
+}
+
+func Benchmark_SearchRGBAPhaseCorr_Wide(b *testing.B) {
+	img, pat := wideSearchData()
+	for i := 0; i < b.N; i++ {
+		SearchRGBAPhaseCorr(img, pat)
+	}
+}
@@ -2,7 +2,15 @@
 // For most functionality, there are three different implementations:
 //
 // 1. Naive Go implementation - rather slow, but hopefully correct
-// 2. Slightly optimized Go version
+// 2. Slightly optimized Go version, also available parallelized over multiple cores
 // 3. Cgo version - fastest
 // 4. Vulkan - even faster
+//
+// Additionally, there are two alternative search strategies:
+//
+// 1. Coarse-to-fine pyramid search - much faster for large search windows
+// 2. FFT phase correlation - insensitive to exposure changes
+//
+// The similarity score is selected by Mode: cosine similarity (default)
+// or zero-mean normalized cross-correlation, both either over RGB or luminance only.
 package pmatch
@@ -0,0 +1,128 @@
+package pmatch
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// PyramidDefaultLevels is the default number of downsampled levels used by the Pyramid Instance.
+	PyramidDefaultLevels = 2
+	// Patches are not downsampled below this size [px].
+	pyramidMinPatSize = 8
+	// Search radius around the upsampled peak from the coarser level [px].
+	pyramidRefineRadius = 2
+)
+
+// downsampleRGBA halves the resolution of an image by averaging 2x2 blocks.
+// Odd trailing rows and columns are dropped. The result has its origin at (0,0), alpha is set to 0xff.
+func downsampleRGBA(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx()/2, img.Rect.Dy()/2
+	ret := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := range h {
+		row0 := img.Pix[2*y*img.Stride:]
+		row1 := img.Pix[(2*y+1)*img.Stride:]
+		dst := ret.Pix[y*ret.Stride:]
+
+		for x := range w {
+			for rgb := range 3 {
+				i := 2*x*four + rgb
+				sum := uint16(row0[i]) + uint16(row0[i+four]) + uint16(row1[i]) + uint16(row1[i+four])
+				dst[x*four+rgb] = uint8((sum + 2) / 4)
+			}
+			dst[x*four+3] = 0xff
+		}
+	}
+
+	return ret
+}
+
+// searchRGBALocal searches for the position of an (RGBA) patch in an (RGBA) image,
+// using the given score (bound to img and pat), only within radius around (cx, cy).
+func searchRGBALocal(img, pat *image.RGBA, cx, cy, radius int, score scoreAtFn) (maxX, maxY int, maxCos float64) {
+	m := img.Bounds().Dx() - pat.Bounds().Dx() + 1
+	n := img.Bounds().Dy() - pat.Bounds().Dy() + 1
+
+	maxCos = math.Inf(-1)
+	for y := max(0, cy-radius); y <= min(n-1, cy+radius); y++ {
+		for x := max(0, cx-radius); x <= min(m-1, cx+radius); x++ {
+			cos := score(image.Pt(x, y))
+			if cos > maxCos {
+				maxCos = cos
+				maxX, maxY = x, y
+			}
+		}
+	}
+
+	return
+}
+
+// SearchRGBAPyramid searches for the position of an (RGBA) patch in an (RGBA) image,
+// using the score given by mode.
+// Image and patch are downsampled up to levels times. An exhaustive search is done only at the coarsest level,
+// the peak is then refined locally at each finer level.
+// This is much faster for large search windows, but might miss the global optimum for patches
+// which mostly have high frequency content.
+// With levels = 0, this is equivalent to SearchRGBAModeC (or SearchRGBAC for ModeCos).
+// Panics if the patch is larger than the image in any dimension.
+// The alpha channel is ignored.
+func SearchRGBAPyramid(img, pat *image.RGBA, levels int, mode Mode) (int, int, float64) {
+	if pat.Bounds().Size().X > img.Bounds().Size().X ||
+		pat.Bounds().Size().Y > img.Bounds().Size().Y {
+		panic("patch too large")
+	}
+
+	imgs := []*image.RGBA{img}
+	pats := []*image.RGBA{pat}
+	for range levels {
+		p := pats[len(pats)-1]
+		if p.Rect.Dx()/2 < pyramidMinPatSize || p.Rect.Dy()/2 < pyramidMinPatSize {
+			break
+		}
+		imgs = append(imgs, downsampleRGBA(imgs[len(imgs)-1]))
+		pats = append(pats, downsampleRGBA(p))
+	}
+
+	top := len(imgs) - 1
+	x, y, cos := mode.searchC()(imgs[top], pats[top])
+	for l := top - 1; l >= 0; l-- {
+		x, y, cos = searchRGBALocal(imgs[l], pats[l], 2*x, 2*y, pyramidRefineRadius, mode.scorerC(imgs[l], pats[l]))
+	}
+
+	return x, y, cos
+}
+
+// Pyramid implements Instance, using SearchRGBAPyramid().
+type Pyramid struct {
+	Levels int
+	Mode   Mode
+}
+
+// Destroy implements Instance.
+func (p *Pyramid) Destroy() {}
+
+// Kind implements Instance.
+func (p *Pyramid) Kind() string {
+	return "Pyramid"
+}
+
+// SearchRGBA implements Instance.
+func (p *Pyramid) SearchRGBA(img *image.RGBA, pat *image.RGBA) (int, int, float64) {
+	return SearchRGBAPyramid(img, pat, p.Levels, p.Mode)
+}
+
+// SearchRGBASubpixel implements Instance.
+func (p *Pyramid) SearchRGBASubpixel(img *image.RGBA, pat *image.RGBA) (float64, float64, float64) {
+	x, y, cos := p.SearchRGBA(img, pat)
+	fx, fy := refineSubpixel(img, pat, x, y, cos, p.Mode.scorerC(img, pat))
+	return fx, fy, cos
+}
+
+// Compile time interface check.
+var _ Instance = (*Pyramid)(nil)
+
+// NewPyramidInstance instantiates Pyramid.
+func NewPyramidInstance(levels int, mode Mode) Instance {
+	return &Pyramid{Levels: levels, Mode: mode}
+}
@@ -0,0 +1,147 @@
+package pmatch
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func Test_downsampleRGBA(t *testing.T) {
+	img := imutil.RandRGBA(123, 9, 7)
+	sub, err := imutil.Sub(img, image.Rect(1, 1, 9, 7))
+	require.NoError(t, err)
+
+	down := downsampleRGBA(sub.(*image.RGBA))
+	assert.Equal(t, image.Rect(0, 0, 4, 3), down.Bounds())
+
+	for y := range 3 {
+		for x := range 4 {
+			var sum [3]int
+			for _, d := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				px := img.RGBAAt(1+2*x+d.X, 1+2*y+d.Y)
+				sum[0] += int(px.R)
+				sum[1] += int(px.G)
+				sum[2] += int(px.B)
+			}
+			px := down.RGBAAt(x, y)
+			assert.Equal(t, uint8((sum[0]+2)/4), px.R)
+			assert.Equal(t, uint8((sum[1]+2)/4), px.G)
+			assert.Equal(t, uint8((sum[2]+2)/4), px.B)
+			assert.Equal(t, uint8(0xff), px.A)
+		}
+	}
+}
+
+func Test_SearchRGBAPyramid(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	x, y, score := SearchRGBAPyramid(img, pat.(*image.RGBA), PyramidDefaultLevels, ModeCos)
+	assert.InDelta(t, 1., score, delta)
+	assert.Equal(t, x0, x)
+	assert.Equal(t, y0, y)
+
+	// Also resets pat bounds origin to (0,0).
+	patCopy := imutil.ToRGBA(pat.(*image.RGBA))
+
+	x, y, score = SearchRGBAPyramid(img, patCopy, PyramidDefaultLevels, ModeCos)
+	assert.InDelta(t, 1., score, delta)
+	assert.Equal(t, x0, x)
+	assert.Equal(t, y0, y)
+}
+
+func Test_SearchRGBAPyramid_VsSlow(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+
+	for _, r := range []image.Rectangle{
+		image.Rect(x0, y0, x0+w, y0+h),
+		image.Rect(10, 10, 50, 42),
+		image.Rect(61, 17, 99, 50),
+		image.Rect(30, 40, 70, 80),
+	} {
+		sub, err := imutil.Sub(img, r)
+		require.NoError(t, err)
+		pat := imutil.ToRGBA(sub)
+		// Slightly alter the patch, so that it is not a perfect match.
+		for i := 0; i < len(pat.Pix); i += 7 {
+			pat.Pix[i] /= 2
+		}
+
+		xS, yS, scoreS := SearchRGBASlow(img, pat)
+		for _, levels := range []int{0, 1, PyramidDefaultLevels, 3} {
+			x, y, score := SearchRGBAPyramid(img, pat, levels, ModeCos)
+			assert.Equal(t, xS, x, "rect=%s levels=%d", r, levels)
+			assert.Equal(t, yS, y, "rect=%s levels=%d", r, levels)
+			assert.InDelta(t, scoreS, score, 1e-12, "rect=%s levels=%d", r, levels)
+		}
+	}
+}
+
+func Test_NewPyramidInstance(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	inst := NewPyramidInstance(PyramidDefaultLevels, ModeCos)
+	defer inst.Destroy()
+
+	x, y, score := inst.SearchRGBA(img, pat.(*image.RGBA))
+	assert.InDelta(t, 1., score, delta)
+	assert.Equal(t, x0, x)
+	assert.Equal(t, y0, y)
+
+	fx, fy, score := inst.SearchRGBASubpixel(img, pat.(*image.RGBA))
+	assert.InDelta(t, 1., score, delta)
+	assert.InDelta(t, x0, fx, 0.05)
+	assert.InDelta(t, y0, fy, 0.05)
+	assert.Equal(t, "Pyramid", inst.Kind())
+}
+
+func Benchmark_SearchRGBAPyramid(b *testing.B) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	if err != nil {
+		b.Error(err)
+	}
+
+	// Make sure pattern lives in a different memory region.
+	pat = imutil.ToRGBA(pat.(*image.RGBA))
+
+	for i := 0; i < b.N; i++ {
+		SearchRGBAPyramid(img, pat.(*image.RGBA), PyramidDefaultLevels, ModeCos)
+	}
+}
+
+// wideSearchData returns image and patch similar to what the stitcher uses for fast trains:
+// A wide and flat search window, with a patch 1/3 its width.
+func wideSearchData() (*image.RGBA, *image.RGBA) {
+	img := imutil.ToRGBA(LoadTestImg())
+	sub, err := imutil.Sub(img, image.Rect(0, 30, 150, 70))
+	if err != nil {
+		panic(err)
+	}
+	search := imutil.ToRGBA(sub)
+	pat, err := imutil.Sub(search, image.Rect(60, 0, 110, 40))
+	if err != nil {
+		panic(err)
+	}
+	return search, imutil.ToRGBA(pat)
+}
+
+func Benchmark_SearchRGBAC_Wide(b *testing.B) {
+	img, pat := wideSearchData()
+	for i := 0; i < b.N; i++ {
+		SearchRGBAC(img, pat)
+	}
+}
+
+func Benchmark_SearchRGBAPyramid_Wide(b *testing.B) {
+	img, pat := wideSearchData()
+	for i := 0; i < b.N; i++ {
+		SearchRGBAPyramid(img, pat, PyramidDefaultLevels, ModeCos)
+	}
+}
@@ -2,6 +2,7 @@ package pmatch
 
 import (
 	"image"
+	"image/color"
 	"math"
 )
 
@@ -85,3 +86,94 @@ func SearchRGBASlow(img, pat *image.RGBA) (maxX, maxY int, maxCos float64) {
 
 	return
 }
+
+// SearchRGBASubpixelSlow is like SearchRGBASlow, but returns the position with sub-pixel precision,
+// interpolated from the scores of the neighboring positions.
+// This a slow implementation useful as ground truth for testing.
+func SearchRGBASubpixelSlow(img, pat *image.RGBA) (maxX, maxY, maxCos float64) {
+	x, y, cos := SearchRGBASlow(img, pat)
+	maxX, maxY = refineSubpixel(img, pat, x, y, cos, bindScore(img, pat, ScoreRGBACosSlow))
+	return maxX, maxY, cos
+}
+
+// ScoreRGBAModeSlow computes the score (according to mode) for an (RGBA) patch
+// on an (RGBA) image at a given offset.
+// This a slow implementation useful as ground truth for testing.
+// The alpha channel is ignored.
+func ScoreRGBAModeSlow(img, pat *image.RGBA, offset image.Point, mode Mode) float64 {
+	img = imgPatchWindow(img, pat, offset).(*image.RGBA)
+
+	values := func(px color.RGBA) []float64 {
+		if mode.luma() {
+			px.A = 0xff
+			return []float64{float64(color.GrayModel.Convert(px).(color.Gray).Y)}
+		}
+		return []float64{float64(px.R), float64(px.G), float64(px.B)}
+	}
+
+	var vI, vP []float64
+	for y := 0; y < pat.Rect.Dy(); y++ {
+		for x := 0; x < pat.Rect.Dx(); x++ {
+			vI = append(vI, values(img.RGBAAt(img.Bounds().Min.X+x, img.Bounds().Min.Y+y))...)
+			vP = append(vP, values(pat.RGBAAt(pat.Bounds().Min.X+x, pat.Bounds().Min.Y+y))...)
+		}
+	}
+
+	if mode.zeroMean() {
+		for _, v := range [][]float64{vI, vP} {
+			var mean float64
+			for _, x := range v {
+				mean += x
+			}
+			mean /= float64(len(v))
+			for i := range v {
+				v[i] -= mean
+			}
+		}
+	}
+
+	var dot, absI2, absP2 float64
+	for i := range vI {
+		dot += vI[i] * vP[i]
+		absI2 += vI[i] * vI[i]
+		absP2 += vP[i] * vP[i]
+	}
+
+	if mode.zeroMean() {
+		const eps = 1e-9
+		if absI2 < eps && absP2 < eps {
+			return 1
+		}
+		if absI2 < eps || absP2 < eps {
+			return 0
+		}
+	} else if absI2*absP2 == 0 {
+		return 1
+	}
+	return dot / math.Sqrt(absI2*absP2)
+}
+
+// SearchRGBAModeSlow searches for the position of an (RGBA) patch in an (RGBA) image,
+// using the score given by mode.
+// This a slow implementation useful as ground truth for testing.
+// The alpha channel is ignored.
+func SearchRGBAModeSlow(img, pat *image.RGBA, mode Mode) (maxX, maxY int, maxScore float64) {
+	searchRect := image.Rectangle{
+		Min: img.Bounds().Min,
+		Max: img.Bounds().Max.Sub(pat.Bounds().Size()).Add(image.Pt(1, 1)),
+	}
+
+	maxScore = math.Inf(-1)
+	for y := 0; y < searchRect.Dy(); y++ {
+		for x := 0; x < searchRect.Dx(); x++ {
+			score := ScoreRGBAModeSlow(img, pat, image.Pt(x, y), mode)
+
+			if score > maxScore {
+				maxScore = score
+				maxX, maxY = x, y
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,54 @@
+package pmatch
+
+import (
+	"image"
+)
+
+// scoreFn computes the similarity of pat at offset in img.
+type scoreFn func(img, pat *image.RGBA, offset image.Point) float64
+
+// scoreAtFn computes the similarity of a given patch at offset in a given image.
+type scoreAtFn func(offset image.Point) float64
+
+// bindScore binds score to img and pat.
+func bindScore(img, pat *image.RGBA, score scoreFn) scoreAtFn {
+	return func(offset image.Point) float64 {
+		return score(img, pat, offset)
+	}
+}
+
+// parabolicPeak fits a parabola through three equidistant samples, with c being the (discrete) maximum,
+// and returns the position of its vertex relative to c, in [-0.5, 0.5].
+func parabolicPeak(l, c, r float64) float64 {
+	denom := l - 2*c + r
+	if denom >= 0 {
+		// Not a maximum (e.g. flat).
+		return 0
+	}
+
+	d := (l - r) / (2 * denom)
+	return max(-0.5, min(0.5, d))
+}
+
+// refineSubpixel refines an integer search result (x, y) to sub-pixel precision,
+// by interpolating the score surface around it separately in x and y.
+// At the border of the search area, no refinement is done in the respective direction.
+// img and pat are only used for the size of the search area, score must be bound to them.
+func refineSubpixel(img, pat *image.RGBA, x, y int, cos float64, score scoreAtFn) (float64, float64) {
+	m := img.Bounds().Dx() - pat.Bounds().Dx() + 1
+	n := img.Bounds().Dy() - pat.Bounds().Dy() + 1
+
+	fx, fy := float64(x), float64(y)
+	if x > 0 && x < m-1 {
+		l := score(image.Pt(x-1, y))
+		r := score(image.Pt(x+1, y))
+		fx += parabolicPeak(l, cos, r)
+	}
+	if y > 0 && y < n-1 {
+		t := score(image.Pt(x, y-1))
+		b := score(image.Pt(x, y+1))
+		fy += parabolicPeak(t, cos, b)
+	}
+
+	return fx, fy
+}
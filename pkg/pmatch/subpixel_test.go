@@ -0,0 +1,96 @@
+package pmatch
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func Test_parabolicPeak(t *testing.T) {
+	assert.Equal(t, 0., parabolicPeak(0.5, 1, 0.5))
+	assert.Equal(t, 0., parabolicPeak(1, 1, 1))
+	assert.InDelta(t, 0.5, parabolicPeak(0, 1, 1), 1e-15)
+	assert.InDelta(t, -0.5, parabolicPeak(1, 1, 0), 1e-15)
+	// Vertex of -(x-0.25)^2.
+	assert.InDelta(t, 0.25, parabolicPeak(-1.5625, -0.0625, -0.5625), 1e-15)
+}
+
+// halfPixelPatch returns a patch of size w x h from img at (x0+0.5, y0), interpolated linearly.
+func halfPixelPatch(img *image.RGBA) *image.RGBA {
+	pat := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := img.RGBAAt(x0+x, y0+y)
+			b := img.RGBAAt(x0+x+1, y0+y)
+			a.R = uint8((int(a.R) + int(b.R) + 1) / 2)
+			a.G = uint8((int(a.G) + int(b.G) + 1) / 2)
+			a.B = uint8((int(a.B) + int(b.B) + 1) / 2)
+			pat.SetRGBA(x, y, a)
+		}
+	}
+	return pat
+}
+
+func Test_SearchRGBASubpixel(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+	patCopy := imutil.ToRGBA(pat.(*image.RGBA))
+	half := halfPixelPatch(img)
+
+	for name, fn := range map[string]func(img, pat *image.RGBA) (float64, float64, float64){
+		"Slow": SearchRGBASubpixelSlow,
+		"Go":   SearchRGBASubpixel,
+		"C":    SearchRGBASubpixelC,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Exact match.
+			x, y, score := fn(img, patCopy)
+			assert.InDelta(t, 1., score, delta)
+			assert.InDelta(t, x0, x, 0.05)
+			assert.InDelta(t, y0, y, 0.05)
+
+			// Half pixel offset.
+			x, y, _ = fn(img, half)
+			assert.InDelta(t, x0+0.5, x, 0.15)
+			// Interpolating also blurs the patch, which makes the score surface slightly asymmetric in y.
+			assert.InDelta(t, y0, y, 0.25)
+		})
+	}
+
+	// All implementations agree.
+	xS, yS, cosS := SearchRGBASubpixelSlow(img, half)
+	xG, yG, cosG := SearchRGBASubpixel(img, half)
+	xC, yC, cosC := SearchRGBASubpixelC(img, half)
+	assert.InDelta(t, xS, xG, 1e-12)
+	assert.InDelta(t, yS, yG, 1e-12)
+	assert.InDelta(t, cosS, cosG, 1e-12)
+	assert.InDelta(t, xS, xC, 1e-12)
+	assert.InDelta(t, yS, yC, 1e-12)
+	assert.InDelta(t, cosS, cosC, 1e-12)
+}
+
+func Test_ScoreRGBACos(t *testing.T) {
+	img := imutil.ToRGBA(LoadTestImg())
+	pat, err := imutil.Sub(img, image.Rect(x0, y0, x0+w, y0+h))
+	require.NoError(t, err)
+
+	testScore(t, img, pat.(*image.RGBA), 1, ScoreRGBACos)
+	testScore(t, img, pat.(*image.RGBA), 1, ScoreRGBACosC)
+
+	for _, off := range []image.Point{{0, 0}, {3, 7}, {x0, y0}, {x0 + 1, y0 - 2}} {
+		slow := ScoreRGBACosSlow(img, pat.(*image.RGBA), off)
+		assert.InDelta(t, slow, ScoreRGBACos(img, pat.(*image.RGBA), off), 1e-12)
+		assert.InDelta(t, slow, ScoreRGBACosC(img, pat.(*image.RGBA), off), 1e-12)
+	}
+
+	assert.Panics(t, func() {
+		ScoreRGBACos(img, pat.(*image.RGBA), image.Pt(-1, 0))
+	})
+	assert.Panics(t, func() {
+		ScoreRGBACosC(img, pat.(*image.RGBA), image.Pt(0, 200))
+	})
+}
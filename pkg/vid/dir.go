@@ -0,0 +1,329 @@
+package vid
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+// DirSidecarFile is the default name of the timestamps sidecar file in a DirSrc directory.
+const DirSidecarFile = "timestamps.csv"
+
+// dirDefaultFPS is used to synthesize timestamps if there is no other source for them.
+const dirDefaultFPS = 30
+
+var dirImageExts = map[string]struct{}{
+	".jpg":  {},
+	".jpeg": {},
+	".png":  {},
+}
+
+// DirConfig is the configuration for a DirSrc.
+//
+// Timestamps are determined as follows, in order of precedence:
+//
+//  1. From the sidecar CSV file, if it exists (see Sidecar). Each line is "filename,timestamp", where timestamp is either RFC3339
+//     or (fractional) seconds since the Unix epoch. A header line is allowed.
+//  2. From the file name, if TSLayout or TSPattern is set.
+//  3. Synthesized from FPS, starting at time.Time{}.
+type DirConfig struct {
+	// Directory containing JPEG or PNG frames. Files are read in order of their timestamps,
+	// or in lexical order of their names if timestamps are synthesized. Duplicate timestamps are an error.
+	Dir string
+	// Sidecar CSV file name, relative to Dir. Defaults to DirSidecarFile.
+	// It is an error if a sidecar file given here does not exist, while the default one is optional.
+	Sidecar string
+	// Regular expression to extract the timestamp from the file name (without extension).
+	// The first submatch is used, or the entire match if there are no submatches.
+	// Defaults to the entire file name.
+	TSPattern string
+	// Go time layout to parse the extracted timestamp with, e.g. "20060102_150405.000".
+	// If empty, the timestamp is parsed as (fractional) seconds since the Unix epoch.
+	TSLayout string
+	// Frame rate used to synthesize timestamps. If 0, defaults to 30.
+	// If timestamps are available, the frame rate is computed from them instead.
+	FPS float64
+}
+
+// DirSrc is a video source which reads an image sequence from a directory.
+// Use NewDirSrc() to create an instance.
+type DirSrc struct {
+	files []string
+	ts    []time.Time
+	fps   float64
+	ix    int
+}
+
+// Compile time interface check.
+var _ Src = (*DirSrc)(nil)
+
+// NewDirSrc lists a directory and prepares it for reading frames.
+func NewDirSrc(c DirConfig) (*DirSrc, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if _, ok := dirImageExts[strings.ToLower(filepath.Ext(e.Name()))]; !ok {
+			continue
+		}
+		files = append(files, e.Name())
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, errors.New("no image files found in directory")
+	}
+
+	// Only the default sidecar file is optional.
+	optional := c.Sidecar == ""
+	if optional {
+		c.Sidecar = DirSidecarFile
+	}
+	sidecar, err := loadSidecar(filepath.Join(c.Dir, c.Sidecar))
+	if err != nil && !(optional && errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("unable to load sidecar file: %w", err)
+	}
+	err = nil
+
+	var ts []time.Time
+	switch {
+	case sidecar != nil:
+		ts, err = sidecarTimestamps(files, sidecar)
+	case c.TSLayout != "" || c.TSPattern != "":
+		ts, err = fileNameTimestamps(files, c.TSPattern, c.TSLayout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ts != nil {
+		err = sortByTimestamp(files, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fps := c.FPS
+	if fps == 0 {
+		fps = dirDefaultFPS
+	}
+	if ts == nil {
+		ts = make([]time.Time, len(files))
+		for i := range files {
+			ts[i] = time.Time{}.Add(time.Duration(float64(i) / fps * float64(time.Second)))
+		}
+	} else if len(ts) > 1 {
+		dur := ts[len(ts)-1].Sub(ts[0]).Seconds()
+		if dur > 0 {
+			fps = float64(len(ts)-1) / dur
+		}
+	}
+
+	for i := range files {
+		files[i] = filepath.Join(c.Dir, files[i])
+	}
+
+	return &DirSrc{
+		files: files,
+		ts:    ts,
+		fps:   fps,
+	}, nil
+}
+
+// sortByTimestamp sorts files and their timestamps ts by timestamp, in place.
+// Returns an error if two files have the same timestamp.
+func sortByTimestamp(files []string, ts []time.Time) error {
+	ix := make([]int, len(files))
+	for i := range ix {
+		ix[i] = i
+	}
+	sort.SliceStable(ix, func(a, b int) bool {
+		return ts[ix[a]].Before(ts[ix[b]])
+	})
+
+	sortedFiles := make([]string, len(files))
+	sortedTS := make([]time.Time, len(ts))
+	for i, j := range ix {
+		sortedFiles[i], sortedTS[i] = files[j], ts[j]
+		if i > 0 && !sortedTS[i].After(sortedTS[i-1]) {
+			return fmt.Errorf("'%s' and '%s' have the same timestamp %s", sortedFiles[i-1], sortedFiles[i], sortedTS[i])
+		}
+	}
+
+	copy(files, sortedFiles)
+	copy(ts, sortedTS)
+	return nil
+}
+
+// parseTimestamp parses a timestamp with a Go time layout, or as (fractional) seconds since the epoch if layout is empty.
+func parseTimestamp(val, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.Parse(layout, val)
+	}
+
+	secs, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s'", val)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(math.Round(frac*float64(time.Second)))), nil
+}
+
+// loadSidecar loads a sidecar CSV file into a map from file name to timestamp.
+func loadSidecar(path string) (map[string]time.Time, error) {
+	// #nosec G304
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	ret := map[string]time.Time{}
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ts, err := time.Parse(time.RFC3339Nano, rec[1])
+		if err != nil {
+			ts, err = parseTimestamp(rec[1], "")
+		}
+		if err != nil {
+			if line == 1 {
+				// Header.
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid timestamp '%s'", line, rec[1])
+		}
+
+		ret[filepath.Base(rec[0])] = ts
+	}
+
+	return ret, nil
+}
+
+func sidecarTimestamps(files []string, sidecar map[string]time.Time) ([]time.Time, error) {
+	ret := make([]time.Time, len(files))
+	for i, f := range files {
+		ts, ok := sidecar[f]
+		if !ok {
+			return nil, fmt.Errorf("no timestamp for '%s' in sidecar file", f)
+		}
+		ret[i] = ts
+	}
+	return ret, nil
+}
+
+func fileNameTimestamps(files []string, pattern, layout string) ([]time.Time, error) {
+	if pattern == "" {
+		pattern = "^.*$"
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp pattern: %w", err)
+	}
+
+	ret := make([]time.Time, len(files))
+	for i, f := range files {
+		name := strings.TrimSuffix(f, filepath.Ext(f))
+		match := re.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("timestamp pattern does not match file name '%s'", f)
+		}
+		val := match[0]
+		if len(match) > 1 {
+			val = match[1]
+		}
+
+		ret[i], err = parseTimestamp(val, layout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse timestamp from file name '%s': %w", f, err)
+		}
+	}
+
+	return ret, nil
+}
+
+// GetFrame implements Src.
+// Frames are always returned as *image.RGBA.
+func (s *DirSrc) GetFrame() (image.Image, *time.Time, error) {
+	if s.ix >= len(s.files) {
+		return nil, nil, io.EOF
+	}
+
+	path, ts := s.files[s.ix], s.ts[s.ix]
+	s.ix++
+
+	img, err := imutil.Load(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load '%s': %w", path, err)
+	}
+
+	return imutil.ToRGBA(img), &ts, nil
+}
+
+// GetFrameRaw implements Src.
+// Only supported for JPEG files.
+func (s *DirSrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	if s.ix >= len(s.files) {
+		return nil, 0, nil, io.EOF
+	}
+
+	path, ts := s.files[s.ix], s.ts[s.ix]
+	s.ix++
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".jpg" && ext != ".jpeg" {
+		return nil, 0, nil, fmt.Errorf("raw frames are only supported for JPEG files: '%s'", path)
+	}
+
+	// #nosec G304
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return buf, FourCCMJPEG, &ts, nil
+}
+
+// IsLive implements Src.
+func (s *DirSrc) IsLive() bool {
+	return false
+}
+
+// GetFPS implements Src.
+func (s *DirSrc) GetFPS() float64 {
+	return s.fps
+}
+
+// Close implements Src.
+func (s *DirSrc) Close() error {
+	return nil
+}
@@ -0,0 +1,130 @@
+package vid
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+func writeDirFrames(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for i, name := range names {
+		err := imutil.Dump(filepath.Join(dir, name), imutil.RandRGBA(int64(i), 20, 10))
+		require.NoError(t, err)
+	}
+	return dir
+}
+
+func Test_DirSrc_FileName(t *testing.T) {
+	dir := writeDirFrames(t, "cam_20240301_120000.100.png", "cam_20240301_120000.000.png", "cam_20240301_120000.200.png")
+	// Not an image, must be ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0600))
+
+	src, err := NewDirSrc(DirConfig{
+		Dir:       dir,
+		TSPattern: `^cam_(.*)$`,
+		TSLayout:  "20060102_150405.000",
+	})
+	require.NoError(t, err)
+	defer src.Close()
+	assert.False(t, src.IsLive())
+	assert.InDelta(t, 10, src.GetFPS(), 1e-9)
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		img, ts, err := src.GetFrame()
+		require.NoError(t, err)
+		assert.Equal(t, 20, img.Bounds().Dx())
+		assert.Equal(t, start.Add(time.Duration(i)*100*time.Millisecond), *ts)
+	}
+
+	_, _, err = src.GetFrame()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func Test_DirSrc_TimestampOrder(t *testing.T) {
+	// Unpadded, lexical order is 1, 10, 2.
+	dir := writeDirFrames(t, "1.png", "10.png", "2.png")
+
+	src, err := NewDirSrc(DirConfig{Dir: dir, TSPattern: `^\d+$`})
+	require.NoError(t, err)
+	for _, expected := range []int64{1, 2, 10} {
+		_, ts, err := src.GetFrame()
+		require.NoError(t, err)
+		assert.Equal(t, expected, ts.Unix())
+	}
+
+	// Duplicate.
+	dir = writeDirFrames(t, "1.png", "1.0.png", "2.png")
+	_, err = NewDirSrc(DirConfig{Dir: dir, TSPattern: `^[\d.]+$`})
+	assert.Error(t, err)
+}
+
+func Test_DirSrc_Sidecar(t *testing.T) {
+	dir := writeDirFrames(t, "a.png", "b.png")
+	sidecar := "file,ts\na.png,1700000000.5\nb.png,2023-11-14T22:13:21Z\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DirSidecarFile), []byte(sidecar), 0600))
+
+	src, err := NewDirSrc(DirConfig{Dir: dir})
+	require.NoError(t, err)
+	assert.InDelta(t, 2, src.GetFPS(), 1e-9)
+
+	_, ts, err := src.GetFrame()
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 5e8), *ts)
+	_, ts, err = src.GetFrame()
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000001, 0).Unix(), ts.Unix())
+
+	// Missing entry.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DirSidecarFile), []byte("a.png,1700000000.5\n"), 0600))
+	_, err = NewDirSrc(DirConfig{Dir: dir})
+	assert.Error(t, err)
+
+	// Configured sidecar file does not exist.
+	_, err = NewDirSrc(DirConfig{Dir: dir, Sidecar: "missing.csv"})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	// The default one is optional.
+	require.NoError(t, os.Remove(filepath.Join(dir, DirSidecarFile)))
+	_, err = NewDirSrc(DirConfig{Dir: dir})
+	assert.NoError(t, err)
+}
+
+func Test_DirSrc_FPS(t *testing.T) {
+	dir := writeDirFrames(t, "0.png", "1.png")
+
+	src, err := NewDirSrc(DirConfig{Dir: dir, FPS: 4})
+	require.NoError(t, err)
+	assert.Equal(t, 4., src.GetFPS())
+
+	_, ts0, err := src.GetFrame()
+	require.NoError(t, err)
+	_, ts1, err := src.GetFrame()
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, ts1.Sub(*ts0))
+
+	_, err = NewDirSrc(DirConfig{Dir: t.TempDir()})
+	assert.Error(t, err)
+}
+
+func Test_DirSrc_Raw(t *testing.T) {
+	frame, err := os.ReadFile(testImage)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1700000000.jpg"), frame, 0600))
+
+	src, err := NewDirSrc(DirConfig{Dir: dir, TSPattern: `^\d+$`})
+	require.NoError(t, err)
+
+	raw, fourcc, ts, err := src.GetFrameRaw()
+	require.NoError(t, err)
+	assert.Equal(t, FourCCMJPEG, fourcc)
+	assert.Equal(t, frame, raw)
+	assert.Equal(t, int64(1700000000), ts.Unix())
+}
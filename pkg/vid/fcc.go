@@ -16,6 +16,8 @@ var (
 	FourCCYUYV = FourCC(v4l2.PixelFmtYUYV)
 	// FourCCYUV420 means yuv420p.
 	FourCCYUV420 = FourCCFromString("YU12")
+	// FourCCPNG means a sequence of PNG images.
+	FourCCPNG = FourCCFromString("MPNG")
 )
 
 // String converts a FourCC code to string, e.g. 1448695129 to YUYV.
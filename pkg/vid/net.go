@@ -0,0 +1,470 @@
+package vid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"jo-m.ch/go/trainbot/internal/pkg/prometheus"
+)
+
+const (
+	netDefaultBackoffMin = time.Millisecond * 500
+	netDefaultBackoffMax = time.Second * 30
+	netFPSLowPassFactor  = 0.9
+	netPTSQueueSize      = 100
+	// netMaxPartSize limits the size of a single MJPEG part we are willing to read.
+	netMaxPartSize = 1024 * 1024 * 20
+)
+
+// IsNetURL returns true if path looks like a URL which can be opened with NewNetSrc.
+func IsNetURL(path string) bool {
+	u, err := url.Parse(path)
+	if err != nil {
+		return false
+	}
+
+	switch u.Scheme {
+	case "rtsp", "rtsps", "http", "https":
+		return true
+	default:
+		return false
+	}
+}
+
+// NetConfig is the configuration for a NetSrc.
+type NetConfig struct {
+	// URL to open, for example rtsp://10.0.0.5:554/stream1 or http://10.0.0.5/video.mjpeg.
+	// The http(s) scheme expects a multipart MJPEG stream, rtsp(s) streams are decoded via ffmpeg.
+	URL string
+	// Nominal frames per second, used for GetFPS(). If 0, the frame rate is estimated from the stream.
+	FPS float64
+	// Initial wait time before reconnecting after the stream has dropped. Defaults to 500ms.
+	BackoffMin time.Duration
+	// Maximum wait time between reconnection attempts. Defaults to 30s.
+	BackoffMax time.Duration
+	// How many times in a row to try to reconnect before giving up. 0 means forever.
+	MaxReconnects int
+}
+
+// netConn is a single connection to a network stream.
+// It is replaced by a new one when the stream drops.
+type netConn interface {
+	// readFrame returns the next raw JPEG frame, and its stream timestamp if available.
+	readFrame() ([]byte, *time.Time, error)
+	close() error
+}
+
+// NetSrc is a video frame source which reads from an IP camera via RTSP or HTTP MJPEG.
+// It reconnects with exponential backoff when the stream drops, and then returns ErrReconnected once.
+// Use NewNetSrc() to open one.
+type NetSrc struct {
+	c NetConfig
+
+	connLock sync.Mutex
+	conn     netConn
+	closed   bool
+
+	lastTS *time.Time
+	fps    float64
+}
+
+// Compile time interface check.
+var _ Src = (*NetSrc)(nil)
+
+// NewNetSrc creates a new NetSrc and connects to the stream.
+// The first connection attempt is not retried, so that configuration errors surface immediately.
+func NewNetSrc(c NetConfig) (*NetSrc, error) {
+	if !IsNetURL(c.URL) {
+		return nil, fmt.Errorf("unsupported URL '%s'", c.URL)
+	}
+	if c.BackoffMin <= 0 {
+		c.BackoffMin = netDefaultBackoffMin
+	}
+	if c.BackoffMax < c.BackoffMin {
+		c.BackoffMax = max(netDefaultBackoffMax, c.BackoffMin)
+	}
+
+	conn, err := dialNet(c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NetSrc{
+		c:    c,
+		conn: conn,
+		fps:  c.FPS,
+	}, nil
+}
+
+func dialNet(rawURL string) (netConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return dialMJPEG(rawURL)
+	case "rtsp", "rtsps":
+		return dialFFmpeg(rawURL)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme '%s'", u.Scheme)
+	}
+}
+
+// reconnect closes the current connection and tries to open a new one, with exponential backoff.
+func (s *NetSrc) reconnect() error {
+	backoff := s.c.BackoffMin
+	for attempt := 1; ; attempt++ {
+		s.connLock.Lock()
+		if s.closed {
+			s.connLock.Unlock()
+			return io.EOF
+		}
+		if s.conn != nil {
+			_ = s.conn.close()
+			s.conn = nil
+		}
+		s.connLock.Unlock()
+
+		log.Warn().Str("url", s.c.URL).Dur("backoff", backoff).Int("attempt", attempt).Msg("reconnecting to network stream")
+		time.Sleep(backoff)
+
+		conn, err := dialNet(s.c.URL)
+		if err == nil {
+			prometheus.RecordSourceReconnect("success")
+			s.connLock.Lock()
+			defer s.connLock.Unlock()
+			if s.closed {
+				_ = conn.close()
+				return io.EOF
+			}
+			s.conn = conn
+			return nil
+		}
+
+		prometheus.RecordSourceReconnect("failure")
+		log.Warn().Err(err).Str("url", s.c.URL).Msg("failed to reconnect")
+		if s.c.MaxReconnects > 0 && attempt >= s.c.MaxReconnects {
+			return fmt.Errorf("giving up after %d reconnection attempts: %w", attempt, err)
+		}
+
+		backoff = min(backoff*2, s.c.BackoffMax)
+	}
+}
+
+// readFrame reads the next frame.
+// If the stream has dropped, reconnects as often as necessary and returns ErrReconnected.
+func (s *NetSrc) readFrame() ([]byte, time.Time, error) {
+	s.connLock.Lock()
+	conn, closed := s.conn, s.closed
+	s.connLock.Unlock()
+	if closed {
+		return nil, time.Time{}, io.EOF
+	}
+
+	if conn != nil {
+		buf, ts, err := conn.readFrame()
+		if err == nil {
+			if ts == nil {
+				now := time.Now()
+				ts = &now
+			}
+			s.updateFPS(*ts)
+			return buf, *ts, nil
+		}
+		log.Warn().Err(err).Str("url", s.c.URL).Msg("network stream dropped")
+	}
+
+	err := s.reconnect()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// Do not estimate the frame rate across the gap.
+	s.lastTS = nil
+	return nil, time.Time{}, ErrReconnected
+}
+
+// updateFPS updates the frame rate estimate, if no nominal frame rate was configured.
+func (s *NetSrc) updateFPS(ts time.Time) {
+	defer func() { s.lastTS = &ts }()
+	if s.c.FPS > 0 || s.lastTS == nil {
+		return
+	}
+
+	dt := ts.Sub(*s.lastTS).Seconds()
+	if dt <= 0 {
+		return
+	}
+	if s.fps == 0 {
+		s.fps = 1 / dt
+		return
+	}
+	s.fps = s.fps*netFPSLowPassFactor + (1/dt)*(1-netFPSLowPassFactor)
+}
+
+// GetFrame implements Src.
+func (s *NetSrc) GetFrame() (image.Image, *time.Time, error) {
+	buf, ts, err := s.readFrame()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to decode frame: %w", err)
+	}
+
+	return img, &ts, nil
+}
+
+// GetFrameRaw implements Src.
+// Frames are always returned as MJPEG.
+func (s *NetSrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	buf, ts, err := s.readFrame()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return buf, FourCCMJPEG, &ts, nil
+}
+
+// IsLive implements Src.
+func (s *NetSrc) IsLive() bool {
+	return true
+}
+
+// GetFPS implements Src.
+func (s *NetSrc) GetFPS() float64 {
+	return s.fps
+}
+
+// Close implements Src.
+func (s *NetSrc) Close() error {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.close()
+	s.conn = nil
+	return err
+}
+
+// mjpegConn reads a multipart MJPEG stream over HTTP, as served by most IP cameras and mjpg-streamer.
+type mjpegConn struct {
+	cancel func()
+	body   io.ReadCloser
+	parts  *multipart.Reader
+}
+
+func dialMJPEG(rawURL string) (*mjpegConn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// #nosec G107
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("unexpected HTTP status '%s'", resp.Status)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("unexpected content type '%s', expected multipart MJPEG stream", mediaType)
+	}
+
+	return &mjpegConn{
+		cancel: cancel,
+		body:   resp.Body,
+		// Some cameras include the leading dashes in the boundary parameter.
+		parts: multipart.NewReader(resp.Body, strings.TrimLeft(params["boundary"], "-")),
+	}, nil
+}
+
+// parsePartTimestamp tries to parse the timestamp header some cameras add to each part.
+// The value is expected to be in (fractional) seconds since the Unix epoch.
+func parsePartTimestamp(part *multipart.Part) *time.Time {
+	val := part.Header.Get("X-Timestamp")
+	if val == "" {
+		return nil
+	}
+
+	secs, err := strconv.ParseFloat(val, 64)
+	if err != nil || secs <= 0 {
+		return nil
+	}
+
+	ts := time.Unix(0, int64(secs*float64(time.Second)))
+	return &ts
+}
+
+func (c *mjpegConn) readFrame() ([]byte, *time.Time, error) {
+	part, err := c.parts.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer part.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(part, netMaxPartSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(buf) == 0 {
+		return nil, nil, errors.New("received empty frame")
+	}
+
+	return buf, parsePartTimestamp(part), nil
+}
+
+func (c *mjpegConn) close() error {
+	c.cancel()
+	return c.body.Close()
+}
+
+// ffmpegConn decodes a stream using an ffmpeg child process, which re-encodes frames to MJPEG.
+// Stream timestamps are extracted from the showinfo filter output on stderr.
+type ffmpegConn struct {
+	proc        *exec.Cmd
+	outPipe     io.ReadCloser
+	errPipe     io.ReadCloser
+	jpegScanner *JPEGScanner
+
+	// Presentation timestamps, as logged by showinfo. Exactly one per frame, in order.
+	pts chan filePTS
+	// Closed by close(), to unblock processErr().
+	done chan struct{}
+	// Wall clock time corresponding to pts == 0, set on the first frame with a timestamp.
+	base *time.Time
+}
+
+func dialFFmpeg(rawURL string) (*ffmpegConn, error) {
+	args := []string{
+		"-hide_banner",
+		"-nostdin",
+		"-loglevel", "info",
+		"-rtsp_transport", "tcp",
+		"-i", rawURL,
+		"-an",
+		"-vf", "showinfo",
+		"-f", "image2pipe",
+		"-c:v", "mjpeg",
+		"-q:v", "2",
+		"pipe:1",
+	}
+
+	// #nosec G204
+	cmd := exec.Command("ffmpeg", args...)
+
+	outPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	errPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &ffmpegConn{
+		proc:        cmd,
+		outPipe:     outPipe,
+		errPipe:     errPipe,
+		jpegScanner: NewJPEGScanner(outPipe),
+		pts:         make(chan filePTS, netPTSQueueSize),
+		done:        make(chan struct{}),
+	}
+
+	go ret.processErr()
+
+	return ret, nil
+}
+
+// processErr parses frame timestamps from ffmpeg stderr, and forwards everything else to the logging system.
+// Queues one entry per frame, also for frames without timestamp, so that timestamps stay in sync with frames.
+// Blocks while the queue is full.
+func (c *ffmpegConn) processErr() {
+	defer close(c.pts)
+
+	scanner := bufio.NewScanner(c.errPipe)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isShowinfoFrameLine(line) {
+			pts, ok := parseShowinfoPTS(line)
+			select {
+			case c.pts <- filePTS{pts: pts, ok: ok}:
+			case <-c.done:
+				return
+			}
+			continue
+		}
+		log.Debug().Str("src", "ffmpeg").Msg(line)
+	}
+}
+
+func (c *ffmpegConn) readFrame() ([]byte, *time.Time, error) {
+	buf, err := c.jpegScanner.Scan()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	p, ok := <-c.pts
+	if !ok || !p.ok {
+		// Falls back to the time of arrival.
+		return buf, nil, nil
+	}
+
+	if c.base == nil {
+		base := now.Add(-time.Duration(p.pts * float64(time.Second)))
+		c.base = &base
+	}
+	ts := c.base.Add(time.Duration(p.pts * float64(time.Second)))
+	return buf, &ts, nil
+}
+
+func (c *ffmpegConn) close() error {
+	close(c.done)
+	_ = c.proc.Process.Signal(os.Kill)
+	return c.proc.Wait()
+}
@@ -0,0 +1,169 @@
+package vid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mjpegHandler serves nFrames frames per connection as a multipart MJPEG stream, then drops the connection.
+func mjpegHandler(t *testing.T, frame []byte, nFrames int, conns *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		conn := conns.Add(1)
+
+		m := multipart.NewWriter(w)
+		defer m.Close()
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+m.Boundary())
+
+		for i := range nFrames {
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Type", "image/jpeg")
+			h.Set("X-Timestamp", fmt.Sprintf("%d.%d", 1700000000+conn*100, i))
+			part, err := m.CreatePart(h)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = part.Write(frame)
+			if !assert.NoError(t, err) {
+				return
+			}
+		}
+	}
+}
+
+func Test_NetSrc_MJPEG(t *testing.T) {
+	frame, err := os.ReadFile(testImage)
+	require.NoError(t, err)
+
+	conns := atomic.Int32{}
+	srv := httptest.NewServer(mjpegHandler(t, frame, 3, &conns))
+	defer srv.Close()
+
+	src, err := NewNetSrc(NetConfig{URL: srv.URL, BackoffMin: time.Millisecond})
+	require.NoError(t, err)
+	defer src.Close()
+	assert.True(t, src.IsLive())
+
+	// Read across several reconnects.
+	var prevTS *time.Time
+	for i := range 9 {
+		img, ts, err := src.GetFrame()
+		if i%4 == 3 {
+			require.ErrorIs(t, err, ErrReconnected)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, 512, img.Bounds().Dx())
+		if prevTS != nil {
+			assert.True(t, ts.After(*prevTS))
+		}
+		prevTS = ts
+	}
+	assert.Equal(t, int32(3), conns.Load())
+	assert.Greater(t, src.GetFPS(), 0.)
+
+	raw, fourcc, _, err := src.GetFrameRaw()
+	require.NoError(t, err)
+	assert.Equal(t, FourCCMJPEG, fourcc)
+	assert.Equal(t, frame, raw)
+}
+
+func Test_NetSrc_GiveUp(t *testing.T) {
+	frame, err := os.ReadFile(testImage)
+	require.NoError(t, err)
+
+	conns := atomic.Int32{}
+	srv := httptest.NewServer(mjpegHandler(t, frame, 1, &conns))
+
+	src, err := NewNetSrc(NetConfig{URL: srv.URL, BackoffMin: time.Millisecond, MaxReconnects: 2})
+	require.NoError(t, err)
+	defer src.Close()
+
+	_, _, err = src.GetFrame()
+	require.NoError(t, err)
+
+	srv.Close()
+	_, _, err = src.GetFrame()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrReconnected)
+}
+
+func Test_IsNetURL(t *testing.T) {
+	assert.True(t, IsNetURL("rtsp://10.0.0.5:554/stream1"))
+	assert.True(t, IsNetURL("http://10.0.0.5/video.mjpeg"))
+	assert.True(t, IsNetURL("https://cam.example.com/video.mjpeg"))
+	assert.False(t, IsNetURL("/dev/video0"))
+	assert.False(t, IsNetURL("video.mp4"))
+	assert.False(t, IsNetURL("picam3"))
+}
+
+func Test_parseShowinfoPTS(t *testing.T) {
+	pts, ok := parseShowinfoPTS("[Parsed_showinfo_0 @ 0x55d0c8c2f0c0] n:   3 pts:   6006 pts_time:0.0667333 duration:   2002 duration_time:0.0222444 fmt:yuv420p")
+	assert.True(t, ok)
+	assert.InDelta(t, 0.0667333, pts, 1e-9)
+
+	_, ok = parseShowinfoPTS("[Parsed_showinfo_0 @ 0x55d0c8c2f0c0] n:   3 pts:NOPTS pts_time:NOPTS")
+	assert.False(t, ok)
+	assert.True(t, isShowinfoFrameLine("[Parsed_showinfo_0 @ 0x55d0c8c2f0c0] n:   3 pts:NOPTS pts_time:NOPTS"))
+
+	_, ok = parseShowinfoPTS("Stream #0:0: Video: h264 (High), yuv420p, 1920x1080, 30 fps")
+	assert.False(t, ok)
+	assert.False(t, isShowinfoFrameLine("Stream #0:0: Video: h264 (High), yuv420p, 1920x1080, 30 fps"))
+}
+
+func Test_ffmpegConn_PTS(t *testing.T) {
+	frame, err := os.ReadFile(testImage)
+	require.NoError(t, err)
+
+	// More frames than fit into the queue, frame 1 has no timestamp.
+	nFrames := netPTSQueueSize * 2
+	var out bytes.Buffer
+	var stderr strings.Builder
+	for i := range nFrames {
+		out.Write(frame)
+		stderr.WriteString("Stream #0:0: Video: mjpeg\n")
+		if i == 1 {
+			fmt.Fprintf(&stderr, "[Parsed_showinfo_0 @ 0x55d0c8c2f0c0] n: %d pts:NOPTS pts_time:NOPTS\n", i)
+			continue
+		}
+		fmt.Fprintf(&stderr, "[Parsed_showinfo_0 @ 0x55d0c8c2f0c0] n: %d pts: %d pts_time:%g duration: 1\n", i, i, float64(i)*0.1)
+	}
+
+	c := &ffmpegConn{
+		errPipe:     io.NopCloser(strings.NewReader(stderr.String())),
+		jpegScanner: NewJPEGScanner(&out),
+		pts:         make(chan filePTS, netPTSQueueSize),
+		done:        make(chan struct{}),
+	}
+	go c.processErr()
+	// Let the queue fill up.
+	time.Sleep(10 * time.Millisecond)
+
+	var ts0 time.Time
+	for i := range nFrames {
+		_, ts, err := c.readFrame()
+		require.NoError(t, err)
+		switch i {
+		case 0:
+			require.NotNil(t, ts)
+			ts0 = *ts
+		case 1:
+			assert.Nil(t, ts)
+		default:
+			require.NotNil(t, ts)
+			assert.InDelta(t, float64(i)*0.1, ts.Sub(ts0).Seconds(), 1e-6, i)
+		}
+	}
+}
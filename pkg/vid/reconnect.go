@@ -0,0 +1,184 @@
+package vid
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"jo-m.ch/go/trainbot/internal/pkg/prometheus"
+)
+
+const (
+	reconnectDefaultBackoffMin = time.Second
+	reconnectDefaultBackoffMax = time.Minute
+)
+
+// Opener opens a video source. See ReconnectingSrc.
+type Opener func() (Src, error)
+
+// ReconnectConfig is the configuration for a ReconnectingSrc.
+type ReconnectConfig struct {
+	// How many consecutive failed frames are tolerated before re-opening the source.
+	// io.EOF from a live source always triggers re-opening immediately.
+	MaxFailedFrames int
+	// Initial wait time before re-opening. Defaults to 1s.
+	BackoffMin time.Duration
+	// Maximum wait time between attempts to re-open. Defaults to 1min.
+	BackoffMax time.Duration
+	// How many times in a row to try to re-open before giving up. 0 means forever.
+	MaxReconnects int
+}
+
+// ReconnectingSrc wraps a live video source, and closes and re-opens it with exponential backoff
+// when it fails. Sources which are not live are passed through unchanged.
+// After the source was re-opened, GetFrame() returns ErrReconnected once.
+// Use NewReconnectingSrc() to create an instance.
+type ReconnectingSrc struct {
+	c      ReconnectConfig
+	open   Opener
+	src    Src
+	failed int
+	// Total number of successful re-opens.
+	reconnects int
+}
+
+// Compile time interface check.
+var _ Src = (*ReconnectingSrc)(nil)
+
+// NewReconnectingSrc creates a new ReconnectingSrc.
+// The source is opened once immediately, and an error is returned if that fails.
+func NewReconnectingSrc(open Opener, c ReconnectConfig) (*ReconnectingSrc, error) {
+	if c.MaxFailedFrames < 1 {
+		c.MaxFailedFrames = 1
+	}
+	if c.BackoffMin <= 0 {
+		c.BackoffMin = reconnectDefaultBackoffMin
+	}
+	if c.BackoffMax < c.BackoffMin {
+		c.BackoffMax = max(reconnectDefaultBackoffMax, c.BackoffMin)
+	}
+
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReconnectingSrc{
+		c:    c,
+		open: open,
+		src:  src,
+	}, nil
+}
+
+// Reconnects returns how many times the source was successfully re-opened.
+func (s *ReconnectingSrc) Reconnects() int {
+	return s.reconnects
+}
+
+// reconnect closes the current source and re-opens it with exponential backoff.
+func (s *ReconnectingSrc) reconnect() error {
+	if s.src != nil {
+		err := s.src.Close()
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to close video source")
+		}
+		s.src = nil
+	}
+
+	backoff := s.c.BackoffMin
+	for attempt := 1; ; attempt++ {
+		log.Warn().Dur("backoff", backoff).Int("attempt", attempt).Msg("re-opening video source")
+		time.Sleep(backoff)
+
+		src, err := s.open()
+		if err == nil {
+			prometheus.RecordSourceReconnect("success")
+			s.src = src
+			s.failed = 0
+			s.reconnects++
+			return nil
+		}
+
+		prometheus.RecordSourceReconnect("failure")
+		log.Warn().Err(err).Msg("failed to re-open video source")
+		if s.c.MaxReconnects > 0 && attempt >= s.c.MaxReconnects {
+			return fmt.Errorf("giving up after %d attempts to re-open: %w", attempt, err)
+		}
+
+		backoff = min(backoff*2, s.c.BackoffMax)
+	}
+}
+
+// GetFrame implements Src.
+func (s *ReconnectingSrc) GetFrame() (image.Image, *time.Time, error) {
+	if s.src == nil {
+		return nil, nil, errors.New("video source was closed or could not be re-opened")
+	}
+
+	frame, ts, err := s.src.GetFrame()
+	if err == nil {
+		s.failed = 0
+		return frame, ts, nil
+	}
+
+	// Pass through from nested reconnecting sources.
+	if errors.Is(err, ErrReconnected) {
+		return nil, nil, err
+	}
+
+	// Files are never re-opened.
+	if !s.src.IsLive() {
+		return nil, nil, err
+	}
+
+	s.failed++
+	if !errors.Is(err, io.EOF) && s.failed < s.c.MaxFailedFrames {
+		return nil, nil, err
+	}
+
+	log.Warn().Err(err).Int("failedFrames", s.failed).Msg("video source failed")
+	err = s.reconnect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nil, nil, ErrReconnected
+}
+
+// GetFrameRaw implements Src.
+// Does not re-open the source on failure.
+func (s *ReconnectingSrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	if s.src == nil {
+		return nil, 0, nil, errors.New("video source was closed or could not be re-opened")
+	}
+	return s.src.GetFrameRaw()
+}
+
+// IsLive implements Src.
+func (s *ReconnectingSrc) IsLive() bool {
+	if s.src == nil {
+		return true
+	}
+	return s.src.IsLive()
+}
+
+// GetFPS implements Src.
+func (s *ReconnectingSrc) GetFPS() float64 {
+	if s.src == nil {
+		return 0
+	}
+	return s.src.GetFPS()
+}
+
+// Close implements Src.
+func (s *ReconnectingSrc) Close() error {
+	if s.src == nil {
+		return nil
+	}
+	err := s.src.Close()
+	s.src = nil
+	return err
+}
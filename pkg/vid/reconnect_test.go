@@ -0,0 +1,126 @@
+package vid
+
+import (
+	"errors"
+	"image"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSrc returns nFrames frames, and then fails with err.
+type fakeSrc struct {
+	nFrames int
+	err     error
+	live    bool
+	closed  bool
+}
+
+func (s *fakeSrc) GetFrame() (image.Image, *time.Time, error) {
+	if s.nFrames == 0 {
+		return nil, nil, s.err
+	}
+	s.nFrames--
+	ts := time.Now()
+	return image.NewRGBA(image.Rect(0, 0, 10, 10)), &ts, nil
+}
+
+func (s *fakeSrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	panic("not implemented")
+}
+
+func (s *fakeSrc) IsLive() bool {
+	return s.live
+}
+
+func (s *fakeSrc) GetFPS() float64 {
+	return 30
+}
+
+func (s *fakeSrc) Close() error {
+	s.closed = true
+	return nil
+}
+
+func Test_ReconnectingSrc(t *testing.T) {
+	var opened []*fakeSrc
+	open := func() (Src, error) {
+		src := &fakeSrc{nFrames: 2, err: errors.New("camera hiccup"), live: true}
+		opened = append(opened, src)
+		return src, nil
+	}
+
+	src, err := NewReconnectingSrc(open, ReconnectConfig{MaxFailedFrames: 2, BackoffMin: time.Millisecond})
+	require.NoError(t, err)
+	defer src.Close()
+
+	for range 2 {
+		_, _, err = src.GetFrame()
+		assert.NoError(t, err)
+	}
+
+	// First failure is passed through.
+	_, _, err = src.GetFrame()
+	assert.EqualError(t, err, "camera hiccup")
+
+	// Second one triggers re-opening.
+	_, _, err = src.GetFrame()
+	assert.ErrorIs(t, err, ErrReconnected)
+	assert.Equal(t, 1, src.Reconnects())
+	require.Len(t, opened, 2)
+	assert.True(t, opened[0].closed)
+	assert.False(t, opened[1].closed)
+
+	_, _, err = src.GetFrame()
+	assert.NoError(t, err)
+}
+
+func Test_ReconnectingSrc_EOF(t *testing.T) {
+	// Live sources are re-opened immediately on EOF.
+	open := func() (Src, error) {
+		return &fakeSrc{nFrames: 1, err: io.EOF, live: true}, nil
+	}
+	src, err := NewReconnectingSrc(open, ReconnectConfig{MaxFailedFrames: 5, BackoffMin: time.Millisecond})
+	require.NoError(t, err)
+
+	_, _, err = src.GetFrame()
+	assert.NoError(t, err)
+	_, _, err = src.GetFrame()
+	assert.ErrorIs(t, err, ErrReconnected)
+
+	// Files are not.
+	open = func() (Src, error) {
+		return &fakeSrc{nFrames: 1, err: io.EOF, live: false}, nil
+	}
+	src, err = NewReconnectingSrc(open, ReconnectConfig{MaxFailedFrames: 5, BackoffMin: time.Millisecond})
+	require.NoError(t, err)
+
+	_, _, err = src.GetFrame()
+	assert.NoError(t, err)
+	_, _, err = src.GetFrame()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, src.Reconnects())
+}
+
+func Test_ReconnectingSrc_GiveUp(t *testing.T) {
+	nOpen := 0
+	open := func() (Src, error) {
+		nOpen++
+		if nOpen > 1 {
+			return nil, errors.New("camera unplugged")
+		}
+		return &fakeSrc{nFrames: 0, err: io.EOF, live: true}, nil
+	}
+	src, err := NewReconnectingSrc(open, ReconnectConfig{BackoffMin: time.Millisecond, MaxReconnects: 3})
+	require.NoError(t, err)
+
+	_, _, err = src.GetFrame()
+	assert.ErrorContains(t, err, "camera unplugged")
+	assert.Equal(t, 4, nOpen)
+
+	_, _, err = src.GetFrame()
+	assert.Error(t, err)
+}
@@ -0,0 +1,395 @@
+package vid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+// Recording format
+//
+// A recording is a directory of segments. Each segment consists of two files with the same base name,
+// which is the UTC timestamp of its first frame (recordingNameFormat):
+//
+//   - <name>.frames: Encoded frames, simply concatenated. For MJPEG, this can be played with e.g. `ffplay -f mjpeg`.
+//   - <name>.idx: A recordingHeader, followed by one recordingEntry per frame. All little endian.
+//
+// The index entry of a frame is written after the frame data, so that a recording interrupted
+// at any point can still be read up to the last complete frame.
+
+const (
+	recordingDataExt    = ".frames"
+	recordingIndexExt   = ".idx"
+	recordingNameFormat = "20060102_150405.000000000"
+
+	recordingDefaultFPS             = 30
+	recordingDefaultQuality         = 95
+	recordingDefaultSegmentDuration = time.Minute
+)
+
+var recordingMagic = [8]byte{'T', 'B', 'R', 'E', 'C', '0', '0', '1'}
+
+type recordingHeader struct {
+	Magic [8]byte
+	// Either FourCCMJPEG or FourCCPNG.
+	Codec FourCC
+	// Bounds().Min of all frames in the segment.
+	OriginX, OriginY int32
+	// If != 0, there was a gap in the stream (e.g. ErrReconnected) before the first frame of this segment.
+	Gap uint8
+	_   [3]uint8
+}
+
+type recordingEntry struct {
+	// Capture timestamp.
+	Sec  int64
+	Nsec int32
+	// Frame data location in the data file.
+	Size   uint32
+	Offset int64
+}
+
+func (e recordingEntry) ts() time.Time {
+	return time.Unix(e.Sec, int64(e.Nsec))
+}
+
+// decodeRecordedFrame decodes a recorded frame and moves it to its original position.
+// Frames are always returned as *image.RGBA.
+func decodeRecordedFrame(buf []byte, codec FourCC, origin image.Point) (*image.RGBA, error) {
+	var img image.Image
+	var err error
+	switch codec {
+	case FourCCMJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(buf))
+	case FourCCPNG:
+		img, err = png.Decode(bytes.NewReader(buf))
+	default:
+		return nil, fmt.Errorf("unknown recording codec '%s'", codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ret := imutil.ToRGBA(img)
+	ret.Rect = ret.Rect.Add(origin)
+	return ret, nil
+}
+
+// recordingSegmentStart parses the start timestamp from a segment file name.
+func recordingSegmentStart(name string) (time.Time, error) {
+	base := filepath.Base(name)
+	if len(base) < len(recordingNameFormat) {
+		return time.Time{}, fmt.Errorf("invalid segment name '%s'", name)
+	}
+	return time.Parse(recordingNameFormat, base[:len(recordingNameFormat)])
+}
+
+// listRecordingSegments returns the base paths (without extension) of all segments in a directory, in order.
+func listRecordingSegments(dir string) ([]string, error) {
+	idx, err := filepath.Glob(filepath.Join(dir, "*"+recordingIndexExt))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, len(idx))
+	for i, f := range idx {
+		ret[i] = strings.TrimSuffix(f, recordingIndexExt)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// IsRecordingDir returns true if the given directory contains a recording written by a RecordingSrc.
+func IsRecordingDir(dir string) bool {
+	segs, err := listRecordingSegments(dir)
+	return err == nil && len(segs) > 0
+}
+
+// RecordingConfig is the configuration for a RecordingSrc.
+type RecordingConfig struct {
+	// Directory to write the recording to. Created if it does not exist.
+	Dir string
+	// Frames are cropped to this rect before recording. If empty, frames are recorded as is.
+	Rect image.Rectangle
+	// Record frames as JPEG instead of PNG. Saves CPU and disk space, but replayed frames are not pixel-exact.
+	Lossy bool
+	// JPEG quality, only used with Lossy. Defaults to 95.
+	Quality int
+	// How long a segment file is at most. Defaults to 1min.
+	SegmentDuration time.Duration
+	// If > 0, segments older than this (relative to the newest frame) are deleted.
+	MaxAge time.Duration
+}
+
+type recordingSegment struct {
+	base   string
+	start  time.Time
+	origin image.Point
+	data   *os.File
+	idx    *os.File
+	offset int64
+}
+
+func (s *recordingSegment) close() error {
+	return errors.Join(s.data.Close(), s.idx.Close())
+}
+
+// RecordingSrc wraps a video source, and records all frames (with their timestamps) passing through GetFrame()
+// to disk, so that they can be played back with a ReplaySrc.
+//
+// Frames are returned untouched, recording does not change what the consumer sees.
+// A ReplaySrc returns the recorded frames cropped to Rect, but with their original bounds.
+// Unless Lossy is set, those are pixel-exact identical to the (cropped) frames which were returned while recording.
+//
+// Use NewRecordingSrc() to create an instance.
+type RecordingSrc struct {
+	c     RecordingConfig
+	src   Src
+	codec FourCC
+	seg   *recordingSegment
+	// If the next segment should be marked as starting after a gap.
+	gap bool
+}
+
+// Compile time interface check.
+var _ Src = (*RecordingSrc)(nil)
+
+// NewRecordingSrc creates a new RecordingSrc wrapping src.
+// The RecordingSrc takes ownership of src.
+func NewRecordingSrc(src Src, c RecordingConfig) (*RecordingSrc, error) {
+	if c.Quality <= 0 {
+		c.Quality = recordingDefaultQuality
+	}
+	if c.SegmentDuration <= 0 {
+		c.SegmentDuration = recordingDefaultSegmentDuration
+	}
+
+	err := os.MkdirAll(c.Dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	codec := FourCCPNG
+	if c.Lossy {
+		codec = FourCCMJPEG
+	}
+
+	return &RecordingSrc{
+		c:     c,
+		src:   src,
+		codec: codec,
+		gap:   true,
+	}, nil
+}
+
+func (s *RecordingSrc) encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if s.c.Lossy {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: s.c.Quality})
+	} else {
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		err = enc.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+func (s *RecordingSrc) closeSegment() {
+	if s.seg == nil {
+		return
+	}
+
+	err := s.seg.close()
+	if err != nil {
+		log.Err(err).Str("segment", s.seg.base).Msg("failed to close recording segment")
+	}
+	s.seg = nil
+}
+
+func (s *RecordingSrc) openSegment(start time.Time, origin image.Point) error {
+	name := start.UTC().Format(recordingNameFormat)
+	base := filepath.Join(s.c.Dir, name)
+	for i := 1; ; i++ {
+		_, err := os.Stat(base + recordingIndexExt)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		base = filepath.Join(s.c.Dir, fmt.Sprintf("%s-%d", name, i))
+	}
+
+	// #nosec G304
+	data, err := os.OpenFile(base+recordingDataExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	// #nosec G304
+	idx, err := os.OpenFile(base+recordingIndexExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return errors.Join(err, data.Close())
+	}
+
+	seg := &recordingSegment{
+		base:   base,
+		start:  start,
+		origin: origin,
+		data:   data,
+		idx:    idx,
+	}
+
+	hdr := recordingHeader{
+		Magic:   recordingMagic,
+		Codec:   s.codec,
+		OriginX: int32(origin.X),
+		OriginY: int32(origin.Y),
+	}
+	if s.gap {
+		hdr.Gap = 1
+	}
+	err = binary.Write(idx, binary.LittleEndian, hdr)
+	if err != nil {
+		return errors.Join(err, seg.close())
+	}
+
+	log.Debug().Str("segment", base).Msg("started new recording segment")
+	s.seg = seg
+	s.gap = false
+	return nil
+}
+
+// prune deletes segments which ended more than MaxAge before now.
+func (s *RecordingSrc) prune(now time.Time) {
+	if s.c.MaxAge <= 0 {
+		return
+	}
+
+	segs, err := listRecordingSegments(s.c.Dir)
+	if err != nil {
+		log.Err(err).Msg("failed to list recording segments")
+		return
+	}
+
+	// A segment ends where the next one starts.
+	for i := 0; i < len(segs)-1; i++ {
+		if s.seg != nil && segs[i] == s.seg.base {
+			continue
+		}
+
+		end, err := recordingSegmentStart(segs[i+1])
+		if err != nil {
+			log.Warn().Err(err).Msg("ignoring invalid recording segment")
+			continue
+		}
+		if now.Sub(end) <= s.c.MaxAge {
+			break
+		}
+
+		log.Debug().Str("segment", segs[i]).Msg("deleting old recording segment")
+		err = errors.Join(os.Remove(segs[i]+recordingDataExt), os.Remove(segs[i]+recordingIndexExt))
+		if err != nil {
+			log.Err(err).Str("segment", segs[i]).Msg("failed to delete recording segment")
+		}
+	}
+}
+
+func (s *RecordingSrc) write(buf []byte, origin image.Point, ts time.Time) error {
+	if s.seg != nil && (ts.Sub(s.seg.start) >= s.c.SegmentDuration || ts.Before(s.seg.start) || origin != s.seg.origin) {
+		s.closeSegment()
+	}
+
+	if s.seg == nil {
+		err := s.openSegment(ts, origin)
+		if err != nil {
+			return err
+		}
+		s.prune(ts)
+	}
+
+	_, err := s.seg.data.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	entry := recordingEntry{
+		Sec:    ts.Unix(),
+		Nsec:   int32(ts.Nanosecond()),
+		Size:   uint32(len(buf)),
+		Offset: s.seg.offset,
+	}
+	s.seg.offset += int64(len(buf))
+	return binary.Write(s.seg.idx, binary.LittleEndian, entry)
+}
+
+// GetFrame implements Src.
+// Failing to record a frame is not fatal, the frame is returned anyways.
+func (s *RecordingSrc) GetFrame() (image.Image, *time.Time, error) {
+	frame, ts, err := s.src.GetFrame()
+	if errors.Is(err, ErrReconnected) {
+		s.closeSegment()
+		s.gap = true
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.record(frame, *ts)
+	if err != nil {
+		log.Err(err).Msg("failed to record frame")
+		s.closeSegment()
+		s.gap = true
+	}
+
+	return frame, ts, nil
+}
+
+// record crops, encodes and writes a frame.
+func (s *RecordingSrc) record(frame image.Image, ts time.Time) error {
+	if !s.c.Rect.Empty() {
+		var err error
+		frame, err = imutil.Sub(frame, s.c.Rect)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf, err := s.encode(frame)
+	if err != nil {
+		return fmt.Errorf("unable to encode frame: %w", err)
+	}
+
+	return s.write(buf, frame.Bounds().Min, ts)
+}
+
+// GetFrameRaw implements Src.
+// Raw frames are passed through, and not recorded.
+func (s *RecordingSrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	return s.src.GetFrameRaw()
+}
+
+// IsLive implements Src.
+func (s *RecordingSrc) IsLive() bool {
+	return s.src.IsLive()
+}
+
+// GetFPS implements Src.
+func (s *RecordingSrc) GetFPS() float64 {
+	return s.src.GetFPS()
+}
+
+// Close implements Src.
+func (s *RecordingSrc) Close() error {
+	s.closeSegment()
+	return s.src.Close()
+}
@@ -0,0 +1,200 @@
+package vid
+
+import (
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"jo-m.ch/go/trainbot/pkg/imutil"
+)
+
+// seqSrc returns random frames with timestamps period apart.
+// Returns ErrReconnected once before frame gapAt, if > 0.
+type seqSrc struct {
+	nFrames int
+	start   time.Time
+	period  time.Duration
+	gapAt   int
+
+	ix          int
+	gapReturned bool
+}
+
+func (s *seqSrc) GetFrame() (image.Image, *time.Time, error) {
+	if s.ix >= s.nFrames {
+		return nil, nil, io.EOF
+	}
+	if s.ix == s.gapAt && s.gapAt > 0 && !s.gapReturned {
+		s.gapReturned = true
+		return nil, nil, ErrReconnected
+	}
+
+	ts := s.start.Add(time.Duration(s.ix) * s.period)
+	img := seqFrame(s.ix)
+	s.ix++
+	return img, &ts, nil
+}
+
+// seqFrame returns the random, but opaque (like camera frames), frame ix of a seqSrc.
+func seqFrame(ix int) *image.RGBA {
+	img := imutil.RandRGBA(int64(ix), 64, 48)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+func (s *seqSrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	panic("not implemented")
+}
+
+func (s *seqSrc) IsLive() bool {
+	return true
+}
+
+func (s *seqSrc) GetFPS() float64 {
+	return float64(time.Second / s.period)
+}
+
+func (s *seqSrc) Close() error {
+	return nil
+}
+
+type recordedFrame struct {
+	img *image.RGBA
+	ts  time.Time
+	gap bool
+}
+
+func readAllFrames(t *testing.T, src Src) []recordedFrame {
+	var ret []recordedFrame
+	gap := false
+	for {
+		img, ts, err := src.GetFrame()
+		if err == io.EOF {
+			return ret
+		}
+		if err == ErrReconnected {
+			gap = true
+			continue
+		}
+		require.NoError(t, err)
+		ret = append(ret, recordedFrame{img.(*image.RGBA), *ts, gap})
+		gap = false
+	}
+}
+
+func Test_RecordingSrc_Replay(t *testing.T) {
+	for _, lossy := range []bool{false, true} {
+		dir := t.TempDir()
+		start := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+		rect := image.Rect(10, 5, 50, 35)
+
+		rec, err := NewRecordingSrc(
+			&seqSrc{nFrames: 20, start: start, period: 100 * time.Millisecond, gapAt: 15},
+			RecordingConfig{Dir: dir, Rect: rect, Lossy: lossy, SegmentDuration: time.Second},
+		)
+		require.NoError(t, err)
+		recorded := readAllFrames(t, rec)
+		require.NoError(t, rec.Close())
+		require.Len(t, recorded, 20)
+		// Frames are passed through untouched.
+		for i := range recorded {
+			assert.Equal(t, seqFrame(i), recorded[i].img)
+		}
+
+		// 2 segments by duration, plus one after the gap.
+		assert.True(t, IsRecordingDir(dir))
+		segs, err := listRecordingSegments(dir)
+		require.NoError(t, err)
+		assert.Len(t, segs, 3)
+
+		replay, err := NewReplaySrc(ReplayConfig{Dir: dir})
+		require.NoError(t, err)
+		assert.False(t, replay.IsLive())
+		assert.InDelta(t, 10, replay.GetFPS(), 1e-9)
+		replayed := readAllFrames(t, replay)
+		require.NoError(t, replay.Close())
+
+		require.Len(t, replayed, len(recorded))
+		for i := range recorded {
+			assert.Equal(t, rect, replayed[i].img.Bounds())
+			if !lossy {
+				cropped, err := imutil.Sub(recorded[i].img, rect)
+				require.NoError(t, err)
+				expected := imutil.ToRGBA(cropped)
+				expected.Rect = expected.Rect.Add(rect.Min)
+				assert.Equal(t, expected, replayed[i].img)
+			}
+			assert.True(t, recorded[i].ts.Equal(replayed[i].ts))
+			assert.Equal(t, i == 15, replayed[i].gap)
+		}
+
+		// Replay a time window only.
+		replay, err = NewReplaySrc(ReplayConfig{Dir: dir, From: start.Add(500 * time.Millisecond), To: start.Add(time.Second)})
+		require.NoError(t, err)
+		replayed = readAllFrames(t, replay)
+		require.Len(t, replayed, 6)
+		assert.True(t, recorded[5].ts.Equal(replayed[0].ts))
+		assert.False(t, replayed[0].gap)
+	}
+}
+
+func Test_RecordingSrc_Ring(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	rec, err := NewRecordingSrc(
+		&seqSrc{nFrames: 100, start: start, period: 100 * time.Millisecond},
+		RecordingConfig{Dir: dir, SegmentDuration: time.Second, MaxAge: 2 * time.Second},
+	)
+	require.NoError(t, err)
+	readAllFrames(t, rec)
+	require.NoError(t, rec.Close())
+
+	segs, err := listRecordingSegments(dir)
+	require.NoError(t, err)
+	// At least the last MaxAge are kept.
+	assert.Len(t, segs, 4)
+
+	replay, err := NewReplaySrc(ReplayConfig{Dir: dir})
+	require.NoError(t, err)
+	replayed := readAllFrames(t, replay)
+	require.Len(t, replayed, 40)
+	assert.True(t, start.Add(6*time.Second).Equal(replayed[0].ts))
+}
+
+func Test_ReplaySrc_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := NewRecordingSrc(
+		&seqSrc{nFrames: 5, start: time.Unix(1700000000, 0), period: 100 * time.Millisecond},
+		RecordingConfig{Dir: dir},
+	)
+	require.NoError(t, err)
+	readAllFrames(t, rec)
+	require.NoError(t, rec.Close())
+
+	// Simulate a crash while writing the last frame.
+	segs, err := listRecordingSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, segs, 1)
+	stat, err := os.Stat(segs[0] + recordingDataExt)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segs[0]+recordingDataExt, stat.Size()-1))
+
+	replay, err := NewReplaySrc(ReplayConfig{Dir: dir})
+	require.NoError(t, err)
+	assert.Len(t, readAllFrames(t, replay), 4)
+
+	raw, fourcc, _, err := replay.GetFrameRaw()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, raw)
+	assert.Equal(t, FourCC(0), fourcc)
+
+	assert.False(t, IsRecordingDir(filepath.Join(dir, "nonexistent")))
+}
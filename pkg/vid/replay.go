@@ -0,0 +1,233 @@
+package vid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayConfig is the configuration for a ReplaySrc.
+type ReplayConfig struct {
+	// Directory containing a recording written by a RecordingSrc.
+	Dir string
+	// If not zero, frames captured before this time are skipped.
+	From time.Time
+	// If not zero, frames captured after this time are skipped.
+	To time.Time
+}
+
+type replaySegment struct {
+	base string
+	hdr  recordingHeader
+}
+
+type replayFrame struct {
+	seg   int
+	entry recordingEntry
+	// If there was a gap in the recording before this frame.
+	gap bool
+}
+
+// ReplaySrc plays back a recording written by a RecordingSrc.
+// Frames are returned as they were recorded by the RecordingSrc (i.e. cropped), always as *image.RGBA.
+// Gaps in the recording (e.g. ErrReconnected while recording) are reported as ErrReconnected.
+// Use NewReplaySrc() to create an instance.
+type ReplaySrc struct {
+	segs   []replaySegment
+	frames []replayFrame
+	fps    float64
+
+	ix int
+	// If ErrReconnected was already returned for frames[ix].
+	gapReturned bool
+
+	data    *os.File
+	dataSeg int
+}
+
+// Compile time interface check.
+var _ Src = (*ReplaySrc)(nil)
+
+// readRecordingIndex reads the index of a segment.
+// Entries pointing beyond the end of the data file, e.g. after a crash while recording, are dropped.
+func readRecordingIndex(base string) (recordingHeader, []recordingEntry, error) {
+	var hdr recordingHeader
+
+	stat, err := os.Stat(base + recordingDataExt)
+	if err != nil {
+		return hdr, nil, err
+	}
+
+	// #nosec G304
+	f, err := os.Open(base + recordingIndexExt)
+	if err != nil {
+		return hdr, nil, err
+	}
+	defer f.Close()
+
+	err = binary.Read(f, binary.LittleEndian, &hdr)
+	if err != nil {
+		return hdr, nil, fmt.Errorf("unable to read header: %w", err)
+	}
+	if hdr.Magic != recordingMagic {
+		return hdr, nil, errors.New("invalid header")
+	}
+
+	var ret []recordingEntry
+	for {
+		var entry recordingEntry
+		err = binary.Read(f, binary.LittleEndian, &entry)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return hdr, nil, err
+		}
+		if entry.Offset+int64(entry.Size) > stat.Size() {
+			break
+		}
+		ret = append(ret, entry)
+	}
+
+	return hdr, ret, nil
+}
+
+// NewReplaySrc opens a recording for playback.
+func NewReplaySrc(c ReplayConfig) (*ReplaySrc, error) {
+	bases, err := listRecordingSegments(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := ReplaySrc{dataSeg: -1}
+	for _, base := range bases {
+		hdr, entries, err := readRecordingIndex(base)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read recording segment '%s': %w", base, err)
+		}
+
+		gap := hdr.Gap != 0
+		for _, e := range entries {
+			ts := e.ts()
+			if (!c.From.IsZero() && ts.Before(c.From)) || (!c.To.IsZero() && ts.After(c.To)) {
+				continue
+			}
+
+			ret.frames = append(ret.frames, replayFrame{
+				seg:   len(ret.segs),
+				entry: e,
+				gap:   gap && len(ret.frames) > 0,
+			})
+			gap = false
+		}
+		ret.segs = append(ret.segs, replaySegment{base: base, hdr: hdr})
+	}
+
+	if len(ret.frames) == 0 {
+		return nil, errors.New("no frames found in recording")
+	}
+
+	ret.fps = recordingDefaultFPS
+	if len(ret.frames) > 1 {
+		dur := ret.frames[len(ret.frames)-1].entry.ts().Sub(ret.frames[0].entry.ts()).Seconds()
+		if dur > 0 {
+			ret.fps = float64(len(ret.frames)-1) / dur
+		}
+	}
+
+	log.Info().Int("frames", len(ret.frames)).Int("segments", len(ret.segs)).Msg("opened recording")
+	return &ret, nil
+}
+
+// next reads the next frame.
+func (s *ReplaySrc) next() ([]byte, replayFrame, error) {
+	if s.ix >= len(s.frames) {
+		return nil, replayFrame{}, io.EOF
+	}
+
+	f := s.frames[s.ix]
+	if f.gap && !s.gapReturned {
+		s.gapReturned = true
+		return nil, replayFrame{}, ErrReconnected
+	}
+	s.gapReturned = false
+	s.ix++
+
+	if s.dataSeg != f.seg {
+		if s.data != nil {
+			s.data.Close()
+		}
+		s.data = nil
+
+		// #nosec G304
+		data, err := os.Open(s.segs[f.seg].base + recordingDataExt)
+		if err != nil {
+			return nil, replayFrame{}, err
+		}
+		s.data = data
+		s.dataSeg = f.seg
+	}
+
+	buf := make([]byte, f.entry.Size)
+	_, err := s.data.ReadAt(buf, f.entry.Offset)
+	if err != nil {
+		return nil, replayFrame{}, err
+	}
+
+	return buf, f, nil
+}
+
+// GetFrame implements Src.
+func (s *ReplaySrc) GetFrame() (image.Image, *time.Time, error) {
+	buf, f, err := s.next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hdr := s.segs[f.seg].hdr
+	img, err := decodeRecordedFrame(buf, hdr.Codec, image.Pt(int(hdr.OriginX), int(hdr.OriginY)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts := f.entry.ts()
+	return img, &ts, nil
+}
+
+// GetFrameRaw implements Src.
+// Returns the recorded frame data, which is either FourCCMJPEG or FourCCPNG.
+func (s *ReplaySrc) GetFrameRaw() ([]byte, FourCC, *time.Time, error) {
+	buf, f, err := s.next()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	ts := f.entry.ts()
+	return buf, s.segs[f.seg].hdr.Codec, &ts, nil
+}
+
+// IsLive implements Src.
+func (s *ReplaySrc) IsLive() bool {
+	return false
+}
+
+// GetFPS implements Src.
+func (s *ReplaySrc) GetFPS() float64 {
+	return s.fps
+}
+
+// Close implements Src.
+func (s *ReplaySrc) Close() error {
+	if s.data == nil {
+		return nil
+	}
+	err := s.data.Close()
+	s.data = nil
+	return err
+}
@@ -0,0 +1,43 @@
+package vid
+
+import (
+	"strconv"
+	"strings"
+)
+
+const showinfoPTSTime = "pts_time:"
+
+// isShowinfoFrameLine returns true if a line was logged by the ffmpeg showinfo filter for a frame,
+// regardless of whether that frame has a valid timestamp.
+func isShowinfoFrameLine(line string) bool {
+	return strings.Contains(line, "showinfo") && strings.Contains(line, showinfoPTSTime)
+}
+
+// parseShowinfoPTS extracts the presentation timestamp (in seconds) from a line
+// logged by the ffmpeg showinfo filter, e.g.
+//
+//	[Parsed_showinfo_0 @ 0x55d0c8c2f0c0] n:   3 pts:   6006 pts_time:0.0667333 duration: ...
+//
+// Returns false if the line is not a showinfo frame line or contains no valid timestamp.
+func parseShowinfoPTS(line string) (float64, bool) {
+	if !strings.Contains(line, "showinfo") {
+		return 0, false
+	}
+
+	ix := strings.Index(line, showinfoPTSTime)
+	if ix < 0 {
+		return 0, false
+	}
+
+	fields := strings.Fields(line[ix+len(showinfoPTSTime):])
+	if len(fields) == 0 {
+		return 0, false
+	}
+
+	pts, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return pts, true
+}
@@ -1,10 +1,17 @@
 package vid
 
 import (
+	"errors"
 	"image"
 	"time"
 )
 
+// ErrReconnected is returned by GetFrame() of live sources which have transparently
+// re-established their underlying connection, e.g. after a camera hiccup.
+// It is not fatal, the next call to GetFrame() will return a frame again.
+// Callers should treat it as a gap in the stream.
+var ErrReconnected = errors.New("video source has reconnected")
+
 // Src describes a frame source.
 type Src interface {
 	// GetFrame retrieves the next frame.
@@ -13,6 +20,7 @@ type Src interface {
 	// invocation.
 	// Returns io.EOF after the last frame, after which Close() should be called
 	// on the instance before discarding it.
+	// Might return ErrReconnected, see there.
 	GetFrame() (image.Image, *time.Time, error)
 
 	// GetFrameRaw retrieves the next frame in the raw pixel format of the source.
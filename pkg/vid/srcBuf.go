@@ -1,6 +1,7 @@
 package vid
 
 import (
+	"errors"
 	"image"
 	"io"
 	"time"
@@ -15,6 +16,8 @@ const queueSize = 200
 type frameWithTS struct {
 	frame image.Image
 	ts    time.Time
+	// Set instead of frame and ts to forward a non-fatal error (ErrReconnected) in order.
+	err error
 }
 
 // SrcBuf buffers a video source.
@@ -56,6 +59,13 @@ func (s *SrcBuf) run() {
 
 	for {
 		frame, ts, err := s.src.GetFrame()
+		if errors.Is(err, ErrReconnected) {
+			log.Warn().Msg("source has reconnected")
+			failedFrames = 0
+			// Never drop this, even for live sources.
+			s.queue <- frameWithTS{err: err}
+			continue
+		}
 		if err != nil {
 			failedFrames++
 			log.Warn().Err(err).Int("failedFrames", failedFrames).Msg("failed to retrieve frame")
@@ -81,23 +91,27 @@ func (s *SrcBuf) run() {
 
 		if live {
 			select {
-			case s.queue <- frameWithTS{frame, *ts}:
+			case s.queue <- frameWithTS{frame: frame, ts: *ts}:
 			default:
 				log.Warn().Msg("dropped frame")
 				prometheus.RecordFrameDisposition("dropped")
 			}
 		} else {
-			s.queue <- frameWithTS{frame, *ts}
+			s.queue <- frameWithTS{frame: frame, ts: *ts}
 		}
 	}
 }
 
 // GetFrame returns the next frame.
-// As soon as this returns an error once, the instance needs to be discarded.
+// As soon as this returns an error other than ErrReconnected once, the instance needs to be discarded.
+// ErrReconnected is forwarded from the underlying source in order with the frames.
 // The underlying image buffer will be owned by the caller, src will not reuse or modify it.
 func (s *SrcBuf) GetFrame() (image.Image, *time.Time, error) {
 	f, ok := <-s.queue
 	if ok {
+		if f.err != nil {
+			return nil, nil, f.err
+		}
 		return f.frame, &f.ts, nil
 	}
 
@@ -15,18 +15,63 @@ import (
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
+const (
+	// How long to wait for the presentation timestamp of a frame before giving up on PTS.
+	fileSrcPTSTimeout   = time.Second * 5
+	fileSrcPTSQueueSize = 1000
+)
+
+// Output pixel formats supported by FileSrc.
+const (
+	// FilePixFmtRGBA outputs *image.RGBA frames.
+	FilePixFmtRGBA = "rgba"
+	// FilePixFmtGray outputs *image.Gray frames.
+	FilePixFmtGray = "gray"
+	// FilePixFmtYUV420 outputs *image.YCbCr frames with 4:2:0 subsampling.
+	FilePixFmtYUV420 = "yuv420p"
+)
+
+// FileConfig is the configuration for a FileSrc.
+type FileConfig struct {
+	// Path to the video file.
+	Path string
+	// Log ffmpeg output.
+	Verbose bool
+	// Start reading at this offset into the file.
+	Start time.Duration
+	// Stop reading after this duration. If 0, read until the end.
+	Duration time.Duration
+	// Crop frames to this rect (inside ffmpeg). Returned frames have this rect as their bounds.
+	// If empty, frames are returned uncropped.
+	// For FilePixFmtYUV420, Rect.Min must have even coordinates.
+	Rect image.Rectangle
+	// Output pixel format, one of the FilePixFmt* constants. Defaults to FilePixFmtRGBA.
+	PixFmt string
+}
+
+// filePTS is the presentation timestamp of a frame, as logged by the ffmpeg showinfo filter.
+type filePTS struct {
+	pts float64
+	ok  bool
+}
+
 // FileSrc is a video file source.
+// Timestamps are taken from the presentation timestamps (PTS) of the frames, relative to the
+// file creation time. If those are not available, they are computed from the average frame rate.
 // Use NewFileSrc() to get an instance.
 type FileSrc struct {
+	c       FileConfig
 	reader  *io.PipeReader
 	writer  *io.PipeWriter
-	w, h    int
+	rect    image.Rectangle
 	buf     []byte
 	startTS time.Time
 	fps     float64
 	count   uint64
 
-	verbose bool
+	pts    chan filePTS
+	noPTS  bool
+	lastTS *time.Time
 
 	ffmpegErr  error
 	ffmpegLock sync.Mutex
@@ -54,9 +99,32 @@ func parseFPS(fps string) (float64, error) {
 	return a / b, nil
 }
 
+// fileFrameSize returns the buffer size for a single frame of a given size and pixel format.
+func fileFrameSize(pixFmt string, sz image.Point) (int, error) {
+	switch pixFmt {
+	case FilePixFmtRGBA:
+		return sz.X * sz.Y * 4, nil
+	case FilePixFmtGray:
+		return sz.X * sz.Y, nil
+	case FilePixFmtYUV420:
+		cw, ch := (sz.X+1)/2, (sz.Y+1)/2
+		return sz.X*sz.Y + 2*cw*ch, nil
+	default:
+		return 0, fmt.Errorf("unsupported pixel format '%s'", pixFmt)
+	}
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
 // NewFileSrc creates a new FileSrc.
-func NewFileSrc(path string, verbose bool) (src *FileSrc, err error) {
-	_, vidProbe, err := Probe(path)
+func NewFileSrc(c FileConfig) (src *FileSrc, err error) {
+	if c.PixFmt == "" {
+		c.PixFmt = FilePixFmtRGBA
+	}
+
+	_, vidProbe, err := Probe(c.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -66,59 +134,75 @@ func NewFileSrc(path string, verbose bool) (src *FileSrc, err error) {
 		return nil, fmt.Errorf("unable to parse fps '%s': %w", vidProbe.RFrameRate, err)
 	}
 
-	reader, writer := io.Pipe()
+	rect := image.Rect(0, 0, vidProbe.Width, vidProbe.Height)
+	if !c.Rect.Empty() {
+		if !c.Rect.In(rect) {
+			return nil, fmt.Errorf("crop rect %s is not within frame %s", c.Rect, rect)
+		}
+		if c.PixFmt == FilePixFmtYUV420 && (c.Rect.Min.X%2 != 0 || c.Rect.Min.Y%2 != 0) {
+			return nil, errors.New("crop rect must start at even coordinates for yuv420p")
+		}
+		rect = c.Rect
+	}
 
-	sz := vidProbe.Width * vidProbe.Height * 4 // TODO: this (4) depends on pixel format
-	buf := make([]byte, sz)
+	sz, err := fileFrameSize(c.PixFmt, rect.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
 
 	s := FileSrc{
+		c:       c,
 		reader:  reader,
 		writer:  writer,
-		w:       vidProbe.Width,
-		h:       vidProbe.Height,
-		buf:     buf,
-		startTS: vidProbe.Tags.CreationTime,
+		rect:    rect,
+		buf:     make([]byte, sz),
+		startTS: vidProbe.Tags.CreationTime.Add(c.Start),
 		fps:     fps,
 		count:   0,
 
-		verbose: verbose,
+		pts: make(chan filePTS, fileSrcPTSQueueSize),
 	}
 
-	go s.run(path)
+	go s.run()
 
 	return &s, nil
 }
 
-func (s *FileSrc) run(path string) {
+func (s *FileSrc) run() {
 	defer s.writer.Close()
 
-	input := ffmpeg.Input(path).
-		Output("pipe:",
-			ffmpeg.KwArgs{
-				// TODO: what about pixel format?
-				"format": "rawvideo", "pix_fmt": "rgba",
-			}).
-		WithOutput(s.writer)
-	if s.verbose {
-		logReader, logWriter := io.Pipe()
-		input.WithErrorOutput(logWriter)
-
-		go func() {
-			defer logReader.Close()
-			defer logWriter.Close()
+	logReader, logWriter := io.Pipe()
+	defer logWriter.Close()
+	go s.readLog(logReader)
 
-			input := bufio.NewReaderSize(logReader, 1024)
-			for {
-				line, _, err := input.ReadLine()
-				if err != nil {
-					log.Info().Err(err).Msg("ffmpeg stderr reader terminated")
-				}
+	inArgs := ffmpeg.KwArgs{}
+	if s.c.Start > 0 {
+		inArgs["ss"] = formatSeconds(s.c.Start)
+	}
+	if s.c.Duration > 0 {
+		inArgs["t"] = formatSeconds(s.c.Duration)
+	}
 
-				log.Info().Str("line", string(line)).Msg("ffmpeg output")
-			}
-		}()
+	stream := ffmpeg.Input(s.c.Path, inArgs)
+	if !s.c.Rect.Empty() {
+		r := s.c.Rect
+		stream = stream.Crop(r.Min.X, r.Min.Y, r.Dx(), r.Dy(), ffmpeg.KwArgs{"exact": 1})
 	}
 
+	input := stream.
+		// Logs the PTS of each frame to stderr.
+		Filter("showinfo", nil).
+		Output("pipe:",
+			ffmpeg.KwArgs{
+				"format": "rawvideo", "pix_fmt": s.c.PixFmt,
+				// Do not duplicate or drop frames to achieve a constant frame rate.
+				"vsync": "passthrough",
+			}).
+		WithOutput(s.writer).
+		WithErrorOutput(logWriter)
+
 	err := input.Run()
 	if err != nil {
 		s.ffmpegLock.Lock()
@@ -127,6 +211,67 @@ func (s *FileSrc) run(path string) {
 	}
 }
 
+// readLog reads ffmpeg stderr and extracts frame PTS.
+func (s *FileSrc) readLog(logReader *io.PipeReader) {
+	defer logReader.Close()
+	defer close(s.pts)
+
+	scanner := bufio.NewScanner(logReader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isShowinfoFrameLine(line) {
+			pts, ok := parseShowinfoPTS(line)
+			s.pts <- filePTS{pts: pts, ok: ok}
+			continue
+		}
+
+		if s.c.Verbose {
+			log.Info().Str("line", line).Msg("ffmpeg output")
+		}
+	}
+
+	if scanner.Err() != nil {
+		log.Info().Err(scanner.Err()).Msg("ffmpeg stderr reader terminated")
+	}
+}
+
+// nextTS determines the timestamp of the frame which was just read.
+func (s *FileSrc) nextTS() time.Time {
+	fallback := func() time.Time {
+		if s.lastTS != nil {
+			return s.lastTS.Add(time.Duration(float64(time.Second) / s.fps))
+		}
+		return s.startTS.Add(time.Second * time.Duration(s.count) / time.Duration(s.fps))
+	}
+
+	if s.noPTS {
+		return fallback()
+	}
+
+	select {
+	case p, ok := <-s.pts:
+		if !ok {
+			log.Warn().Msg("no frame PTS available, falling back to computed timestamps")
+			s.noPTS = true
+			return fallback()
+		}
+		if !p.ok {
+			log.Debug().Uint64("frame", s.count).Msg("frame has no PTS")
+			return fallback()
+		}
+		return s.startTS.Add(time.Duration(p.pts * float64(time.Second)))
+	case <-time.After(fileSrcPTSTimeout):
+		log.Warn().Msg("timeout waiting for frame PTS, falling back to computed timestamps")
+		s.noPTS = true
+		// Drain, so that the log reader never blocks.
+		go func() {
+			for range s.pts {
+			}
+		}()
+		return fallback()
+	}
+}
+
 // GetFrame implements Src.
 func (s *FileSrc) GetFrame() (image.Image, *time.Time, error) {
 	s.ffmpegLock.Lock()
@@ -142,15 +287,41 @@ func (s *FileSrc) GetFrame() (image.Image, *time.Time, error) {
 		return nil, nil, io.EOF
 	}
 
-	ts := s.startTS.Add(time.Second * time.Duration(s.count) / time.Duration(s.fps))
+	ts := s.nextTS()
+	s.lastTS = &ts
 	s.count++
 
-	rect := image.Rectangle{Max: image.Point{X: s.w, Y: s.h}}
-	return &image.RGBA{
-		Pix:    s.buf,
-		Stride: 4 * rect.Dx(),
-		Rect:   rect,
-	}, &ts, nil
+	return s.frame(), &ts, nil
+}
+
+// frame wraps the frame buffer into an image.
+func (s *FileSrc) frame() image.Image {
+	w, h := s.rect.Dx(), s.rect.Dy()
+	switch s.c.PixFmt {
+	case FilePixFmtGray:
+		return &image.Gray{
+			Pix:    s.buf,
+			Stride: w,
+			Rect:   s.rect,
+		}
+	case FilePixFmtYUV420:
+		cw, ch := (w+1)/2, (h+1)/2
+		return &image.YCbCr{
+			Y:              s.buf[:w*h],
+			Cb:             s.buf[w*h : w*h+cw*ch],
+			Cr:             s.buf[w*h+cw*ch:],
+			YStride:        w,
+			CStride:        cw,
+			SubsampleRatio: image.YCbCrSubsampleRatio420,
+			Rect:           s.rect,
+		}
+	default:
+		return &image.RGBA{
+			Pix:    s.buf,
+			Stride: 4 * w,
+			Rect:   s.rect,
+		}
+	}
 }
 
 // GetFrameRaw implements Src.
@@ -0,0 +1,64 @@
+package vid
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileSrc_nextTS(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := FileSrc{
+		startTS: start,
+		fps:     10,
+		pts:     make(chan filePTS, 10),
+	}
+	next := func() time.Time {
+		ts := s.nextTS()
+		s.lastTS = &ts
+		s.count++
+		return ts
+	}
+
+	// Variable frame rate.
+	s.pts <- filePTS{pts: 0, ok: true}
+	s.pts <- filePTS{pts: 0.05, ok: true}
+	s.pts <- filePTS{pts: 0.25, ok: true}
+	// No PTS for a single frame.
+	s.pts <- filePTS{ok: false}
+	close(s.pts)
+
+	assert.Equal(t, start, next())
+	assert.Equal(t, start.Add(50*time.Millisecond), next())
+	assert.Equal(t, start.Add(250*time.Millisecond), next())
+	assert.Equal(t, start.Add(350*time.Millisecond), next())
+
+	// PTS no longer available.
+	assert.Equal(t, start.Add(450*time.Millisecond), next())
+	assert.True(t, s.noPTS)
+	assert.Equal(t, start.Add(550*time.Millisecond), next())
+}
+
+func Test_FileSrc_frame(t *testing.T) {
+	rect := image.Rect(4, 2, 9, 7)
+	for _, pixFmt := range []string{FilePixFmtRGBA, FilePixFmtGray, FilePixFmtYUV420} {
+		sz, err := fileFrameSize(pixFmt, rect.Size())
+		require.NoError(t, err)
+
+		s := FileSrc{c: FileConfig{PixFmt: pixFmt}, rect: rect, buf: make([]byte, sz)}
+		img := s.frame()
+		assert.Equal(t, rect, img.Bounds())
+		// Last pixel must be addressable.
+		img.At(rect.Max.X-1, rect.Max.Y-1)
+	}
+
+	sz, err := fileFrameSize(FilePixFmtYUV420, image.Pt(5, 5))
+	require.NoError(t, err)
+	assert.Equal(t, 5*5+2*3*3, sz)
+
+	_, err = fileFrameSize("bgr24", image.Pt(5, 5))
+	assert.Error(t, err)
+}